@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	adminListen  = flag.String("admin-listen", "", "address (host:port, or unix:/path/to.sock) to serve the admin api on")
+	adminToken   = flag.String("admin-token", "", "bearer token required by the admin api (required unless listening on a unix socket)")
+	adminPersist = flag.Bool("admin-persist", false, "save changes made via the admin api back to the -targets file")
+)
+
+// adminServer is a small HTTP api for managing the targets at runtime:
+//
+//	GET    /targets               list the targets
+//	POST   /targets               add a target, e.g. {"host": "cache01", "port": 6380}
+//	DELETE /targets/NAME          remove a target
+//	POST   /targets/NAME/pause    stop collecting from a target
+//	POST   /targets/NAME/resume   start collecting from a paused target
+//	GET    /status                when each target's collectors last ran, and next will
+//	GET    /metrics.json          the metrics of the latest interval of each instance,
+//	                              or with ?instance=NAME, of just that one
+type adminServer struct {
+	targets *Targets
+	latest  *latestOutput
+	token   string
+	persist bool
+}
+
+// serveAdmin starts the admin api in the background.
+func serveAdmin(addr string, targets *Targets, latest *latestOutput) error {
+	var ln net.Listener
+	var err error
+
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		os.Remove(path)
+
+		ln, err = net.Listen("unix", path)
+		if err != nil {
+			return err
+		}
+
+		// The socket's permissions are the access control, so keep it to
+		// the user the collector runs as.
+		err = os.Chmod(path, 0600)
+		if err != nil {
+			return err
+		}
+	} else {
+		if *adminToken == "" {
+			return fmt.Errorf("-admin-token is required to serve the admin api over tcp")
+		}
+
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *adminPersist && targets.path == "" {
+		return fmt.Errorf("-admin-persist requires -targets")
+	}
+
+	s := &adminServer{
+		targets: targets,
+		latest:  latest,
+		token:   *adminToken,
+		persist: *adminPersist,
+	}
+
+	fmt.Printf("# serving admin api: %s\n", addr)
+	go http.Serve(ln, s)
+	return nil
+}
+
+func (s *adminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "status" {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.reply(w, s.targets.Status())
+		return
+	}
+
+	if len(parts) == 1 && parts[0] == "metrics.json" {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		instance := r.URL.Query().Get("instance")
+		v, ok := s.latest.latest(instance)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no metrics for instance: %s", instance), http.StatusNotFound)
+			return
+		}
+
+		s.reply(w, v)
+		return
+	}
+
+	if parts[0] != "targets" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var err error
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		s.reply(w, s.targets.List())
+		return
+
+	case len(parts) == 1 && r.Method == "POST":
+		t := &Target{}
+		err = json.NewDecoder(r.Body).Decode(t)
+		if err == nil {
+			err = s.targets.Add(t)
+		}
+
+	case len(parts) == 2 && r.Method == "DELETE":
+		err = s.targets.Remove(parts[1])
+
+	case len(parts) == 3 && r.Method == "POST" && parts[2] == "pause":
+		err = s.targets.SetPaused(parts[1], true)
+
+	case len(parts) == 3 && r.Method == "POST" && parts[2] == "resume":
+		err = s.targets.SetPaused(parts[1], false)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.persist {
+		err = s.targets.Save()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error saving targets: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.reply(w, s.targets.List())
+}
+
+func (s *adminServer) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The targets which are nodes of the same cluster (i.e. have the same cluster
+// option) are also reported as a whole, so that dashboards can show the
+// logical database rather than each of its shards. The aggregates are computed
+// from the latest INFO of each node, whenever any of them is collected, and
+// reported with the cluster's name as the instance.
+//
+// A master and its replicas can be given the same cluster option too, and
+// then the read commands served by the replicas (as opposed to the master)
+// show how well reads are being spread over them.
+//
+// Targets can belong to groups too, which needn't be clusters, e.g. every
+// server used as a cache, whose aggregates are reported in the group section
+// (with the group's name as the instance), for service level dashboards. A
+// target can be in several groups.
+
+// latest returns the fields of the target's latest INFO, or nil if it hasn't
+// been collected yet.
+func (t *Target) latest() *sample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.prev
+}
+
+// An aggregate is the sum (or worst case) of some fields across the nodes of
+// a cluster, or the targets of a group.
+type aggregate struct {
+	nodes      int
+	keys       float64
+	memory     float64
+	ops        float64
+	replicaLag float64
+
+	// The lowest percentage of key lookups which hit, of the nodes which
+	// have had any, since they started.
+	minHitPercent float64
+	hitNodes      int
+
+	masterReads  float64
+	replicaReads float64
+}
+
+func (a *aggregate) add(s *sample) {
+	a.nodes++
+
+	for k, v := range s.values {
+		if k[0] == "keyspace" && strings.HasSuffix(k[1], "/keys") {
+			a.keys += v
+		}
+	}
+
+	if v, ok := s.value("memory", "used_memory"); ok {
+		a.memory += v
+	}
+
+	if v, ok := s.value("stats", "instantaneous_ops_per_sec"); ok {
+		a.ops += v
+	}
+
+	// Only replicas report how long it's been since they heard from their
+	// master. It's -1 while they're disconnected, which doesn't count here,
+	// since it's not a lag.
+	if v, ok := s.value("replication", "master_last_io_seconds_ago"); ok && v > a.replicaLag {
+		a.replicaLag = v
+	}
+
+	hits, _ := s.value("stats", "keyspace_hits")
+	misses, _ := s.value("stats", "keyspace_misses")
+	if hits+misses > 0 {
+		pct := hits / (hits + misses) * 100
+		if a.hitNodes == 0 || pct < a.minHitPercent {
+			a.minHitPercent = pct
+		}
+
+		a.hitNodes++
+	}
+
+	switch s.role {
+	case "master", "cluster_master":
+		a.masterReads += s.reads
+	case "replica", "cluster_replica":
+		a.replicaReads += s.reads
+	}
+}
+
+func (a *aggregate) metrics(instance, section string) Metrics {
+	ms := make(Metrics, 0, 8)
+	add := func(key string, f float64) {
+		ms = append(ms, &Metric{
+			Instance: instance,
+			Section:  section,
+			Key:      key,
+			Value:    strconv.FormatFloat(f, 'f', -1, 64),
+		})
+	}
+
+	add("nodes_reporting", float64(a.nodes))
+	add("keys", a.keys)
+	add("used_memory", a.memory)
+	add("instantaneous_ops_per_sec", a.ops)
+	add("replica_lag_seconds_max", a.replicaLag)
+	add("master_read_commands_per_sec", a.masterReads)
+	add("replica_read_commands_per_sec", a.replicaReads)
+
+	if reads := a.masterReads + a.replicaReads; reads > 0 {
+		add("replica_read_percent", a.replicaReads/reads*100)
+	}
+
+	if a.hitNodes > 0 {
+		add("keyspace_hit_percent_min", a.minHitPercent)
+	}
+
+	return ms
+}
+
+// An aggregateKey identifies a cluster (in the cluster section) or a group
+// (in the group section).
+type aggregateKey struct {
+	section, name string
+}
+
+// writeAggregates writes the aggregates of each cluster and group which had a
+// node collected at now. A node which hasn't been collected for two of its
+// intervals (e.g. it's down) is left out, rather than adding its last sample
+// to the totals forever.
+func writeAggregates(now time.Time, interval time.Duration, targets *Targets, out Output) {
+	aggs := map[aggregateKey]*aggregate{}
+	fresh := map[aggregateKey]bool{}
+
+	for _, t := range targets.Active() {
+		if t.Cluster == "" && len(t.Groups) == 0 {
+			continue
+		}
+
+		s := t.latest()
+		if s == nil || now.Sub(s.t) > 2*t.every("info", interval) {
+			continue
+		}
+
+		keys := make([]aggregateKey, 0, len(t.Groups)+1)
+		if t.Cluster != "" {
+			keys = append(keys, aggregateKey{"cluster", t.Cluster})
+		}
+
+		for _, g := range t.Groups {
+			keys = append(keys, aggregateKey{"group", g})
+		}
+
+		for _, k := range keys {
+			a, ok := aggs[k]
+			if !ok {
+				a = &aggregate{}
+				aggs[k] = a
+			}
+
+			a.add(s)
+			if s.t.Equal(now) {
+				fresh[k] = true
+			}
+		}
+	}
+
+	keys := make([]aggregateKey, 0, len(fresh))
+	for k := range fresh {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].section != keys[j].section {
+			return keys[i].section < keys[j].section
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	for _, k := range keys {
+		err := out.Write(now, aggs[k].metrics(k.name, k.section))
+		if err != nil {
+			fmt.Println("error writing metrics:")
+			fmt.Println(err)
+		}
+	}
+}
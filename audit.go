@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	auditLogPath = flag.String("audit-log", "", "log every command sent to redis to this file, or to stderr if it's -")
+)
+
+// auditLog is where commands are logged. Connections to every target share
+// it, so writes are serialized.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var audit *auditLog
+
+// openAuditLog opens the -audit-log file, if there is one. The file is
+// appended to, so that it survives restarts.
+func openAuditLog() error {
+	switch *auditLogPath {
+	case "":
+		return nil
+
+	case "-":
+		audit = &auditLog{w: os.Stderr}
+		return nil
+	}
+
+	f, err := os.OpenFile(*auditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	audit = &auditLog{w: f}
+	return nil
+}
+
+// log records that cmd was sent to target at t, and took d to reply.
+func (a *auditLog) log(t time.Time, target string, cmd string, args []interface{}, d time.Duration, err error) {
+	words := make([]string, 0, len(args)+1)
+	words = append(words, strings.ToUpper(cmd))
+	for i, arg := range args {
+		s := fmt.Sprint(arg)
+		if redactArg(cmd, args, i) {
+			s = "[redacted]"
+		}
+		words = append(words, s)
+	}
+
+	line := fmt.Sprintf("time=%s target=%s cmd=%s duration=%s", t.UTC().Format(time.RFC3339Nano), strconv.Quote(target), strconv.Quote(strings.Join(words, " ")), d)
+	if err != nil {
+		line += " error=" + strconv.Quote(err.Error())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fmt.Fprintln(a.w, line)
+}
+
+// redactArg returns true if the i'th argument of the command is a secret,
+// which mustn't be logged.
+func redactArg(cmd string, args []interface{}, i int) bool {
+	switch strings.ToUpper(cmd) {
+	case "AUTH":
+		return true
+
+	case "HELLO":
+		// HELLO [protover [AUTH username password] [SETNAME name]]
+		for j := 0; j < i; j++ {
+			if strings.ToUpper(fmt.Sprint(args[j])) == "AUTH" {
+				return j == i-2
+			}
+		}
+	}
+
+	return false
+}
+
+// auditConn is a connection which logs every command sent to it.
+type auditConn struct {
+	redis.Conn
+	target string
+}
+
+func (c *auditConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		return c.Conn.Do(cmd, args...)
+	}
+
+	start := time.Now()
+	reply, err := c.Conn.Do(cmd, args...)
+	audit.log(start, c.target, cmd, args, time.Since(start), err)
+	return reply, err
+}
+
+func (c *auditConn) Send(cmd string, args ...interface{}) error {
+	err := c.Conn.Send(cmd, args...)
+	audit.log(time.Now(), c.target, cmd, args, 0, err)
+	return err
+}
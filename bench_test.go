@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// These benchmarks cover the hot path of each interval. To check a change for
+// regressions, run them before and after with:
+//
+//	go test -run '^$' -bench . -benchmem -count 10 > bench_output.txt
+//
+// and compare the two outputs with benchstat.
+
+func BenchmarkParseInfo(b *testing.B) {
+	blob, err := ioutil.ReadFile("testdata/info.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(blob)))
+	for i := 0; i < b.N; i++ {
+		_, err := parseInfo(blob)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCollectdWrite(b *testing.B) {
+	blob, err := ioutil.ReadFile("testdata/info.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ms, err := parseInfo(blob)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	out := benchCollectdOutput(b)
+	t := time.Now()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := out.Write(t, ms)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCycle runs whole intervals: fetching from (synthetic) targets,
+// parsing, and writing to a discarded collectd output.
+func BenchmarkCycle(b *testing.B) {
+	for _, bc := range []struct {
+		name      string
+		instances int
+		metrics   int
+	}{
+		{"1x100", 1, 100},
+		{"1x5000", 1, 5000},
+		{"50x1000", 50, 1000},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			*syntheticMetrics = bc.metrics
+			targets, err := getSyntheticTargets(bc.instances)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			out := benchCollectdOutput(b)
+			t := time.Now()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				collect(t, targets, out)
+				t = t.Add(10 * time.Second)
+			}
+
+			b.ReportMetric(float64(bc.instances*bc.metrics*b.N)/b.Elapsed().Seconds(), "metrics/s")
+		})
+	}
+}
+
+func benchCollectdOutput(b *testing.B) *collectdOutput {
+	out, err := newCollectdOutput(10 * time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	out.w = ioutil.Discard
+	return out
+}
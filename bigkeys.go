@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	bigKeys          = flag.Bool("big-keys", false, "report the sizes of the largest keys, found by scanning the keyspace a little each run")
+	bigKeysTop       = flag.Int("big-keys-top", 10, "how many of the largest keys to report, for -big-keys")
+	bigKeysScan      = flag.Int("big-keys-scan", 1000, "how many keys to look at each run, for -big-keys")
+	bigKeysThreshold = flag.Int64("big-keys-threshold", 0, "send a notification (of the big_key type) when a key is found which is larger than this many bytes, for -big-keys (0 for none)")
+)
+
+// A key with millions of elements, or a string of hundreds of megabytes, is
+// slow to read, and blocks the server while it's deleted or migrated. The
+// keyspace is scanned for them, -big-keys-scan keys per run (every five
+// minutes by default), asking each its MEMORY USAGE. The largest
+// -big-keys-top keys seen since the scan last started from the beginning of
+// the keyspace are reported in the big_keys section, by rank (top1, top2,
+// etc). Key names would make a series per key, so they're only given by the
+// notifications: when a key over -big-keys-threshold is found, it's reported
+// once (until it shrinks below it) in the big_key section, which collectd
+// sends as a notification.
+
+// A bigKey is a key and its size.
+type bigKey struct {
+	key   string
+	bytes int64
+}
+
+// A bigKeyScanner scans a target for its biggest keys.
+type bigKeyScanner struct {
+	scan   int
+	cursor string
+
+	// The sizes of the biggest keys seen in this scan of the keyspace, the
+	// keys over the threshold which have been notified, and those seen over
+	// it in this scan.
+	sizes    map[string]int64
+	notified map[string]bool
+	over     map[string]bool
+}
+
+var bigKeysCollector = &collector{
+	name:     "big_keys",
+	commands: []string{"SCAN", "MEMORY USAGE"},
+	every:    5 * time.Minute,
+	newCollect: func(tg *Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+		s := &bigKeyScanner{scan: tg.scanBudget(*bigKeysScan), cursor: "0", sizes: map[string]int64{}, notified: map[string]bool{}, over: map[string]bool{}}
+		return s.collect
+	},
+}
+
+// checkBigKeys returns an error if the -big-keys flags are invalid.
+func checkBigKeys() error {
+	if *bigKeysTop < 1 || *bigKeysScan < 1 {
+		return fmt.Errorf("-big-keys-top and -big-keys-scan must be at least 1")
+	}
+
+	if *bigKeysThreshold < 0 {
+		return fmt.Errorf("-big-keys-threshold must be at least 0")
+	}
+
+	return nil
+}
+
+func (s *bigKeyScanner) collect(conn redis.Conn, t time.Time) (Metrics, error) {
+	ms := make(Metrics, 0)
+	done := false
+
+	for n := 0; n < s.scan && !done; {
+		reply, err := redis.Values(conn.Do("SCAN", s.cursor, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []string
+		_, err = redis.Scan(reply, &s.cursor, &keys)
+		if err != nil {
+			return nil, err
+		}
+
+		n += len(keys)
+		done = s.cursor == "0"
+
+		for _, k := range keys {
+			bytes, err := redis.Int64(conn.Do("MEMORY", "USAGE", k))
+			if err == redis.ErrNil {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			s.sizes[k] = bytes
+
+			if *bigKeysThreshold == 0 || bytes <= *bigKeysThreshold {
+				delete(s.notified, k)
+				continue
+			}
+
+			s.over[k] = true
+			if !s.notified[k] {
+				s.notified[k] = true
+				ms = append(ms, bigKeyNotification(k, bytes)...)
+			}
+		}
+
+		s.trim()
+	}
+
+	top := s.top()
+	add := func(prefix, key string, n int64) {
+		ms = append(ms, &Metric{Section: "big_keys", Prefix: prefix, Key: key, Value: strconv.FormatInt(n, 10)})
+	}
+
+	for i, bk := range top {
+		add(fmt.Sprintf("top%d", i+1), "bytes", bk.bytes)
+	}
+
+	if *bigKeysThreshold > 0 {
+		add("", "over_threshold_keys", int64(len(s.notified)))
+	}
+
+	// Once the whole keyspace has been scanned, the next scan starts again,
+	// so that keys which have been deleted drop out (and are notified again
+	// if they come back).
+	if done {
+		s.sizes = map[string]int64{}
+		s.notified = s.over
+		s.over = map[string]bool{}
+	}
+
+	return ms, nil
+}
+
+// top returns the biggest keys seen, biggest first.
+func (s *bigKeyScanner) top() []bigKey {
+	list := make([]bigKey, 0, len(s.sizes))
+	for k, b := range s.sizes {
+		list = append(list, bigKey{k, b})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].bytes != list[j].bytes {
+			return list[i].bytes > list[j].bytes
+		}
+		return list[i].key < list[j].key
+	})
+
+	if len(list) > *bigKeysTop {
+		list = list[:*bigKeysTop]
+	}
+
+	return list
+}
+
+// trim forgets the sizes of the keys which aren't among the biggest, so that
+// scanning a big keyspace doesn't remember every key in it.
+func (s *bigKeyScanner) trim() {
+	if len(s.sizes) <= *bigKeysTop {
+		return
+	}
+
+	sizes := make(map[string]int64, *bigKeysTop)
+	for _, bk := range s.top() {
+		sizes[bk.key] = bk.bytes
+	}
+
+	s.sizes = sizes
+}
+
+// bigKeyNotification returns the notification that the key is over the
+// threshold, as the metrics of the big_key section.
+func bigKeyNotification(key string, bytes int64) Metrics {
+	add := func(name, value string) *Metric {
+		return &Metric{Section: "big_key", Prefix: key, Key: name, Value: value}
+	}
+
+	return Metrics{
+		add("key", key),
+		add("bytes", strconv.FormatInt(bytes, 10)),
+		add("threshold_bytes", strconv.FormatInt(*bigKeysThreshold, 10)),
+	}
+}
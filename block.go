@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	blocked = listFlag{}
+)
+
+func init() {
+	flag.Var(&blocked, "block", "never send redis this command, or subcommand (e.g. SCAN, CONFIG or CONFIG GET), and skip the collectors which need it (repeatable)")
+}
+
+// Some servers are too fragile (or too big) for some commands to be sent to
+// them at all, even read-only ones, e.g. SCAN of a huge keyspace. Blocking a
+// command, with -block for every target or a target's own block list for just
+// that target, stops it from ever being sent. The background collectors which
+// need it are skipped, and report that they were, rather than failing.
+
+// checkBlock returns an error if the block list is malformed, or blocks a
+// command which is needed to collect anything at all.
+func checkBlock(list []string) error {
+	for _, b := range list {
+		if strings.TrimSpace(b) == "" {
+			return fmt.Errorf("empty command")
+		}
+
+		for _, cmd := range []string{"PING", "INFO"} {
+			if blocks(b, cmd) {
+				return fmt.Errorf("%s is needed to collect anything, so can't be blocked", cmd)
+			}
+		}
+	}
+
+	return nil
+}
+
+// blocks returns true if the block list entry b blocks cmd (as returned by
+// commandName). Blocking a command blocks all of its subcommands.
+func blocks(b, cmd string) bool {
+	b = strings.ToUpper(strings.Join(strings.Fields(b), " "))
+	return cmd == b || strings.HasPrefix(cmd, b+" ")
+}
+
+// blocked returns true if cmd (as returned by commandName) mustn't be sent to
+// the target.
+func (t *Target) blocked(cmd string) bool {
+	for _, list := range [][]string{blocked, t.Block} {
+		for _, b := range list {
+			if blocks(b, cmd) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// blockedBy returns the first of the commands which the collector needs that
+// is blocked for the target, or "" if none are.
+func (t *Target) blockedBy(c *collector) string {
+	for _, cmd := range c.commands {
+		if t.blocked(cmd) {
+			return cmd
+		}
+	}
+
+	return ""
+}
+
+// skip records that the collector was due to run for the target at now, but
+// was skipped (for the reason why), and writes a metric saying so.
+func (t *Target) skip(now time.Time, c *collector, why string, out Output) {
+	t.ran(c.name, now, fmt.Errorf("skipped: %s", why))
+
+	ms := Metrics{&Metric{Instance: t.instance(), Section: "collector", Prefix: c.name, Key: "skipped", Value: "1"}}
+	err := out.Write(now, ms)
+	if err != nil {
+		fmt.Println("error writing metrics:")
+		fmt.Println(err)
+	}
+}
+
+// blockConn is a connection which refuses to send the commands which are
+// blocked for its target. Collectors which need them are skipped, so this is
+// a last line of defence, like safeConn.
+type blockConn struct {
+	redis.Conn
+	target *Target
+}
+
+func (c *blockConn) check(cmd string, args ...interface{}) error {
+	name := commandName(cmd, args...)
+	if c.target.blocked(name) {
+		return fmt.Errorf("%s is blocked for %s (see -block)", name, c.target.Name)
+	}
+
+	return nil
+}
+
+func (c *blockConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	// An empty command just flushes and receives pending replies.
+	if cmd != "" {
+		err := c.check(cmd, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.Conn.Do(cmd, args...)
+}
+
+func (c *blockConn) Send(cmd string, args ...interface{}) error {
+	err := c.check(cmd, args...)
+	if err != nil {
+		return err
+	}
+
+	return c.Conn.Send(cmd, args...)
+}
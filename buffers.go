@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	outputBuffers = flag.Bool("output-buffers", false, "report how close the clients of each class are to their output buffer limits (needs CLIENT LIST, which is slow with many clients)")
+)
+
+// The client classes which output buffer limits are set for.
+var bufferClasses = []string{"normal", "replica", "pubsub"}
+
+// A bufferLimit is the output buffer limit of a client class, in bytes. Zero
+// means no limit.
+type bufferLimit struct {
+	hard int64
+	soft int64
+}
+
+// parseBufferLimits parses the value of client-output-buffer-limit, which is
+// a list of class, hard limit, soft limit and soft seconds, e.g.
+//
+//	normal 0 0 0 replica 268435456 67108864 60 pubsub 33554432 8388608 60
+//
+// Older servers call replicas slaves.
+func parseBufferLimits(s string) (map[string]bufferLimit, error) {
+	fields := strings.Fields(s)
+	if len(fields)%4 != 0 {
+		return nil, fmt.Errorf("bad client-output-buffer-limit: %q", s)
+	}
+
+	limits := map[string]bufferLimit{}
+	for i := 0; i < len(fields); i += 4 {
+		hard, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad client-output-buffer-limit: %q", s)
+		}
+
+		soft, err := strconv.ParseInt(fields[i+2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad client-output-buffer-limit: %q", s)
+		}
+
+		class := fields[i]
+		if class == "slave" {
+			class = "replica"
+		}
+
+		limits[class] = bufferLimit{hard, soft}
+	}
+
+	return limits, nil
+}
+
+// parseClientList parses the reply to CLIENT LIST, which has a line per
+// client of space separated name=value fields.
+func parseClientList(s string) []map[string]string {
+	clients := make([]map[string]string, 0)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		c := make(map[string]string, len(fields))
+		for _, f := range fields {
+			if i := strings.IndexByte(f, '='); i >= 0 {
+				c[f[:i]] = f[i+1:]
+			}
+		}
+
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+// clientClass returns the output buffer limit class of a client, from its
+// CLIENT LIST flags.
+func clientClass(flags string) string {
+	switch {
+	case strings.ContainsRune(flags, 'S'):
+		return "replica"
+	case strings.ContainsRune(flags, 'P'):
+		return "pubsub"
+	default:
+		return "normal"
+	}
+}
+
+// outputBuffersCollector reports the largest output buffer of the clients of
+// each class, and how large it is as a percentage of the class's hard and soft
+// limits. Clients which reach the hard limit (or stay over the soft one) are
+// disconnected, which for replicas means a full resync, so this shows it
+// coming.
+var outputBuffersCollector = &collector{
+	name:     "output_buffers",
+	commands: []string{"CONFIG GET", "CLIENT LIST"},
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		cfg, err := redis.StringMap(conn.Do("CONFIG", "GET", "client-output-buffer-limit"))
+		if err != nil {
+			return nil, err
+		}
+
+		limits, err := parseBufferLimits(cfg["client-output-buffer-limit"])
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := redis.String(conn.Do("CLIENT", "LIST"))
+		if err != nil {
+			return nil, err
+		}
+
+		max := map[string]int64{}
+		for _, c := range parseClientList(list) {
+			omem, err := strconv.ParseInt(c["omem"], 10, 64)
+			if err != nil {
+				continue
+			}
+
+			class := clientClass(c["flags"])
+			if omem > max[class] {
+				max[class] = omem
+			}
+		}
+
+		ms := make(Metrics, 0)
+		add := func(class, key string, f float64) {
+			ms = append(ms, &Metric{
+				Section: "clients",
+				Prefix:  "output_buffer_" + class,
+				Key:     key,
+				Value:   strconv.FormatFloat(f, 'f', -1, 64),
+			})
+		}
+
+		for _, class := range bufferClasses {
+			add(class, "max_bytes", float64(max[class]))
+
+			l := limits[class]
+			if l.hard > 0 {
+				add(class, "hard_limit_percent", float64(max[class])/float64(l.hard)*100)
+			}
+
+			if l.soft > 0 {
+				add(class, "soft_limit_percent", float64(max[class])/float64(l.soft)*100)
+			}
+		}
+
+		return ms, nil
+	},
+}
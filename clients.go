@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	clientList = flag.Bool("client-list", false, "report what the clients are doing, from CLIENT LIST: how many of each kind there are, which commands they last ran, how large their output buffers are, and how long they've been idle (slow with many clients)")
+)
+
+// INFO only says how many clients there are, and the size of the largest
+// buffers. When one of them suddenly uses gigabytes of output buffer (e.g. a
+// MONITOR, or a KEYS on a big keyspace), or thousands of idle connections
+// leak from an app, the details are in CLIENT LIST. It's a line per client,
+// so it's summed up rather than reported client by client.
+
+// The upper bounds of the idle time buckets, and their names. Clients idle
+// for longer than the last are counted in idle_over_1h.
+var idleBuckets = []struct {
+	name string
+	max  time.Duration
+}{
+	{"idle_under_10s", 10 * time.Second},
+	{"idle_under_1m", time.Minute},
+	{"idle_under_10m", 10 * time.Minute},
+	{"idle_under_1h", time.Hour},
+}
+
+// clientListCollector reports, in the client_list section, how many clients
+// are connected of each kind (normal, replica and pubsub, and how many are
+// blocked), how many last ran each command (with the command as the prefix),
+// the total and largest output buffer, and how many clients have been idle
+// for how long.
+var clientListCollector = &collector{
+	name:     "client_list",
+	commands: []string{"CLIENT LIST"},
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		list, err := redis.String(conn.Do("CLIENT", "LIST"))
+		if err != nil {
+			return nil, err
+		}
+
+		return clientListMetrics(parseClientList(list)), nil
+	},
+}
+
+// clientListMetrics sums up the clients, as parsed from CLIENT LIST.
+func clientListMetrics(clients []map[string]string) Metrics {
+	kinds := map[string]int64{}
+	commands := map[string]int64{}
+	idle := make([]int64, len(idleBuckets)+1)
+	cmds := make([]string, 0)
+	omemTotal, omemMax := int64(0), int64(0)
+
+	for _, c := range clients {
+		kinds[clientClass(c["flags"])]++
+		if strings.ContainsRune(c["flags"], 'b') {
+			kinds["blocked"]++
+		}
+
+		if cmd := c["cmd"]; cmd != "" {
+			// Subcommands are written container|subcommand, e.g.
+			// client|list.
+			cmd = strings.Replace(cmd, "|", "_", -1)
+			if _, ok := commands[cmd]; !ok {
+				cmds = append(cmds, cmd)
+			}
+
+			commands[cmd]++
+		}
+
+		if omem, err := strconv.ParseInt(c["omem"], 10, 64); err == nil {
+			omemTotal += omem
+			if omem > omemMax {
+				omemMax = omem
+			}
+		}
+
+		if secs, err := strconv.ParseInt(c["idle"], 10, 64); err == nil {
+			d := time.Duration(secs) * time.Second
+			i := 0
+			for i < len(idleBuckets) && d >= idleBuckets[i].max {
+				i++
+			}
+
+			idle[i]++
+		}
+	}
+
+	ms := make(Metrics, 0)
+	add := func(prefix, key string, n int64) {
+		ms = append(ms, &Metric{Section: "client_list", Prefix: prefix, Key: key, Value: strconv.FormatInt(n, 10)})
+	}
+
+	add("", "clients", int64(len(clients)))
+	for _, kind := range []string{"normal", "replica", "pubsub", "blocked"} {
+		add("", kind+"_clients", kinds[kind])
+	}
+
+	add("", "output_buffer_total_bytes", omemTotal)
+	add("", "output_buffer_max_bytes", omemMax)
+
+	for i, b := range idleBuckets {
+		add("", b.name, idle[i])
+	}
+
+	add("", "idle_over_1h", idle[len(idleBuckets)])
+
+	for _, cmd := range cmds {
+		add("cmd_"+cmd, "clients", commands[cmd])
+	}
+
+	return ms
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	clusterSlots = flag.Bool("cluster-slots", false, "collect slot coverage and migration metrics from cluster nodes")
+	clusterLinks = flag.Bool("cluster-links", false, "collect metrics of each cluster bus link from cluster nodes (redis 7 and later)")
+)
+
+const clusterSlotCount = 16384
+
+// A clusterNode is a node of a Redis Cluster, as described by CLUSTER NODES.
+type clusterNode struct {
+	id        string
+	addr      string
+	flags     map[string]bool
+	master    string
+	linkState string
+
+	// The number of slots the node serves, and the number it's importing
+	// from and migrating to other nodes.
+	slots     int
+	importing int
+	migrating int
+}
+
+// parseClusterNodes parses the reply to CLUSTER NODES. Each line looks like:
+//
+//	<id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+//
+// where each slot is a number, a range like 0-5460, or a slot which is being
+// moved, like [93->-<node id>] (migrating) or [93-<-<node id>] (importing).
+func parseClusterNodes(s string) ([]*clusterNode, error) {
+	nodes := make([]*clusterNode, 0)
+
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("bad CLUSTER NODES line: %q", line)
+		}
+
+		n := &clusterNode{
+			id:        fields[0],
+			addr:      strings.SplitN(strings.SplitN(fields[1], ",", 2)[0], "@", 2)[0],
+			flags:     map[string]bool{},
+			master:    fields[3],
+			linkState: fields[7],
+		}
+
+		for _, f := range strings.Split(fields[2], ",") {
+			n.flags[f] = true
+		}
+
+		for _, slot := range fields[8:] {
+			switch {
+			case strings.Contains(slot, "->-"):
+				n.migrating++
+
+			case strings.Contains(slot, "-<-"):
+				n.importing++
+
+			default:
+				tupl := strings.SplitN(slot, "-", 2)
+				lo, err := strconv.Atoi(tupl[0])
+				if err != nil {
+					return nil, fmt.Errorf("bad slot: %q", slot)
+				}
+
+				hi := lo
+				if len(tupl) == 2 {
+					hi, err = strconv.Atoi(tupl[1])
+					if err != nil {
+						return nil, fmt.Errorf("bad slot: %q", slot)
+					}
+				}
+
+				n.slots += hi - lo + 1
+			}
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+// clusterDisabled returns true if err is the reply to a CLUSTER command sent to
+// a server which isn't a cluster node.
+func clusterDisabled(err error) bool {
+	re, ok := err.(redis.Error)
+	return ok && strings.Contains(string(re), "cluster support disabled")
+}
+
+// fetchClusterNodes returns the nodes of the cluster which conn is connected
+// to, or nil if it's not a cluster node.
+func fetchClusterNodes(conn redis.Conn) ([]*clusterNode, error) {
+	s, err := redis.String(conn.Do("CLUSTER", "NODES"))
+	if err != nil {
+		if clusterDisabled(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return parseClusterNodes(s)
+}
+
+// fetchClusterInfo returns the fields of CLUSTER INFO, in the cluster section,
+// with cluster_state as 1 if it's ok, or 0 if not.
+func fetchClusterInfo(conn redis.Conn) (Metrics, error) {
+	s, err := redis.String(conn.Do("CLUSTER", "INFO"))
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make(Metrics, 0)
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if v := strings.TrimPrefix(line, "cluster_state:"); v != line {
+			state := "0"
+			if v == "ok" {
+				state = "1"
+			}
+
+			ms = append(ms, &Metric{Section: "cluster", Key: "cluster_state", Value: state})
+			continue
+		}
+
+		ms, _ = appendLine(ms, "cluster", line)
+	}
+
+	return ms, nil
+}
+
+// clusterSlotsCollector reports how many slots each master serves, how many
+// are covered by the cluster as a whole, and how many are being moved between
+// nodes, so that resharding and coverage gaps can be seen.
+var clusterSlotsCollector = &collector{
+	name:     "cluster_slots",
+	commands: []string{"CLUSTER NODES"},
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		nodes, err := fetchClusterNodes(conn)
+		if err != nil || nodes == nil {
+			return nil, err
+		}
+
+		ms := make(Metrics, 0)
+		add := func(prefix, key string, n int) {
+			ms = append(ms, &Metric{Section: "cluster", Prefix: prefix, Key: key, Value: strconv.Itoa(n)})
+		}
+
+		covered, importing, migrating := 0, 0, 0
+		for _, n := range nodes {
+			if !n.flags["master"] {
+				continue
+			}
+
+			add("node_"+n.addr, "slots", n.slots)
+			if !n.flags["fail"] {
+				covered += n.slots
+			}
+			importing += n.importing
+			migrating += n.migrating
+		}
+
+		add("", "slots_covered", covered)
+		add("", "slots_uncovered", clusterSlotCount-covered)
+		add("", "slots_importing", importing)
+		add("", "slots_migrating", migrating)
+		return ms, nil
+	},
+}
+
+// clusterLinksCollector reports the state of each of the node's cluster bus
+// links (CLUSTER LINKS, from Redis 7): the size of their send buffers, which
+// is where congestion of the bus shows first, and how long ago they were
+// established, which shows links being dropped and reconnected.
+var clusterLinksCollector = &collector{
+	name:     "cluster_links",
+	commands: []string{"CLUSTER LINKS"},
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		links, err := redis.Values(conn.Do("CLUSTER", "LINKS"))
+		if err != nil {
+			if clusterDisabled(err) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		ms := make(Metrics, 0)
+		counts := map[string]int{}
+		for _, l := range links {
+			link, err := redis.Values(l, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			fields := map[string]string{}
+			for i := 0; i+1 < len(link); i += 2 {
+				k, _ := redis.String(link[i], nil)
+				switch v := link[i+1].(type) {
+				case []byte:
+					fields[k] = string(v)
+				case int64:
+					fields[k] = strconv.FormatInt(v, 10)
+				}
+			}
+
+			dir := fields["direction"]
+			counts[dir]++
+
+			prefix := "link_" + dir + "_" + fields["node"]
+			for _, k := range []string{"send-buffer-allocated", "send-buffer-used", "events"} {
+				if _, err := strconv.ParseInt(fields[k], 10, 64); err == nil {
+					ms = append(ms, &Metric{Section: "cluster", Prefix: prefix, Key: strings.Replace(k, "-", "_", -1), Value: fields[k]})
+				}
+			}
+
+			if created, err := strconv.ParseInt(fields["create-time"], 10, 64); err == nil {
+				age := t.Sub(time.Unix(0, created*int64(time.Millisecond))).Seconds()
+				ms = append(ms, &Metric{Section: "cluster", Prefix: prefix, Key: "age_seconds", Value: strconv.FormatFloat(age, 'f', 3, 64)})
+			}
+		}
+
+		for _, dir := range []string{"to", "from"} {
+			ms = append(ms, &Metric{Section: "cluster", Key: "links_" + dir, Value: strconv.Itoa(counts[dir])})
+		}
+
+		return ms, nil
+	},
+}
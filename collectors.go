@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Some metrics are much slower to collect than INFO, e.g. those which need a
+// CLIENT LIST of a server with tens of thousands of clients, or a SCAN of its
+// keyspace. They're fetched by background collectors, each of which runs on
+// its own connection and goroutine per target, so that a slow one can never
+// delay the core metrics. If a collector is still running when it's due to
+// run again, that run is skipped. If the server's ACLs don't allow one of its
+// commands (i.e. it replies NOPERM), the collector is skipped for that target
+// from then on.
+//
+// Background collectors need a real connection of their own, so they don't
+// run against synthetic targets, or when replaying.
+
+// A collector fetches one class of metrics from a target.
+type collector struct {
+	name string
+
+	// The commands which the collector sends, for safe mode to check.
+	commands []string
+
+	// How often it runs by default, or if zero, every interval.
+	every time.Duration
+
+	// Whether it reports on particular keys, rather than the server, so
+	// is routed to the node which owns each key in a cluster (see
+	// shards.go).
+	keyed bool
+
+	collect func(conn redis.Conn, t time.Time) (Metrics, error)
+
+	// If set, it's called instead for each target to make its own collect
+	// func, for collectors which keep state from one run to the next (or
+	// need connections of their own).
+	newCollect func(t *Target) func(conn redis.Conn, t time.Time) (Metrics, error)
+}
+
+// enabledCollectors returns the background collectors which are enabled by
+// the flags.
+func enabledCollectors() []*collector {
+	cs := make([]*collector, 0)
+
+	if *clusterSlots {
+		cs = append(cs, clusterSlotsCollector)
+	}
+
+	if *clusterLinks {
+		cs = append(cs, clusterLinksCollector)
+	}
+
+	if *slowlogSummary {
+		cs = append(cs, slowlogCollector())
+	}
+
+	if *slowlogClients {
+		cs = append(cs, slowlogClientsCollector)
+	}
+
+	if *outputBuffers {
+		cs = append(cs, outputBuffersCollector)
+	}
+
+	if len(parsedDBSizes) > 0 {
+		cs = append(cs, dbsizeCollector)
+	}
+
+	if len(parsedProbes) > 0 {
+		cs = append(cs, probesCollector())
+	}
+
+	if len(streamKeys) > 0 {
+		cs = append(cs, streamsCollector)
+	}
+
+	if *pubsubStats {
+		cs = append(cs, pubsubCollector())
+	}
+
+	if len(parsedPatterns) > 0 {
+		cs = append(cs, patternsCollector)
+	}
+
+	if *ttlCoverage {
+		cs = append(cs, ttlCoverageCollector)
+	}
+
+	if *bigKeys {
+		cs = append(cs, bigKeysCollector)
+	}
+
+	if *clientList {
+		cs = append(cs, clientListCollector)
+	}
+
+	if *utilization {
+		cs = append(cs, utilizationCollector)
+	}
+
+	if *memoryStats {
+		cs = append(cs, memoryStatsCollector)
+	}
+
+	if *expiryLag {
+		cs = append(cs, expiryLagCollector)
+	}
+
+	if *scriptStats {
+		cs = append(cs, scriptsCollector)
+	}
+
+	if *latencyEvents {
+		cs = append(cs, latencyCollector())
+	}
+
+	if *latencyReset != "" {
+		cs = append(cs, latencyResetCollector)
+	}
+
+	return cs
+}
+
+// A backgroundRun is the state of a background collector for one target.
+type backgroundRun struct {
+	c       *collector
+	target  *Target
+	collect func(conn redis.Conn, t time.Time) (Metrics, error)
+
+	// The target's semaphore of running collectors, if it has one.
+	slots chan struct{}
+
+	// Held while the collector is running, and guards conn, and the
+	// connections to the other nodes of the target's cluster, by address.
+	mu        sync.Mutex
+	conn      redis.Conn
+	redirects map[string]redis.Conn
+}
+
+// A collected is the result of running a background collector.
+type collected struct {
+	t         time.Time
+	target    string
+	collector string
+	ms        Metrics
+	err       error
+}
+
+// Background starts the collector for the target on its own goroutine, unless
+// it's still running from last time. The result is sent to results.
+func (t *Target) Background(now time.Time, c *collector, results chan<- collected) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.removed || t.dial != nil {
+		return
+	}
+
+	if t.background == nil {
+		t.background = map[string]*backgroundRun{}
+	}
+
+	b, ok := t.background[c.name]
+	if !ok {
+		b = &backgroundRun{c: c, target: t, collect: c.collect, slots: t.slots()}
+		if c.newCollect != nil {
+			b.collect = c.newCollect(t)
+		}
+
+		t.background[c.name] = b
+	}
+
+	if !b.mu.TryLock() {
+		return
+	}
+
+	go b.run(now, results)
+}
+
+// run runs the collector once. It must be called with b.mu held, which it
+// releases when it's done.
+func (b *backgroundRun) run(now time.Time, results chan<- collected) {
+	defer b.mu.Unlock()
+
+	// Waiting for a slot isn't counted as part of the run.
+	if b.slots != nil {
+		b.slots <- struct{}{}
+		defer func() { <-b.slots }()
+	}
+
+	start := time.Now()
+	res := collected{t: now, target: b.target.Name, collector: b.c.name}
+	if b.conn == nil {
+		b.conn, res.err = b.dial()
+	}
+
+	if res.err == nil {
+		res.ms, res.err = b.collect(b.collectConn(), now)
+		if noPerm(res.err) {
+			b.target.deny(b.c.name, res.err)
+		}
+
+		if res.err != nil {
+			b.conn.Close()
+			b.conn = nil
+			b.dropRedirects()
+		}
+	}
+
+	// The keys of a discovered cluster are reported under the cluster, by
+	// whichever node owns them.
+	instance := b.target.instance()
+	if b.c.keyed && b.target.seed != "" {
+		instance = b.target.Cluster
+	}
+	for _, m := range res.ms {
+		m.Instance = instance
+	}
+
+	b.target.ran(b.c.name, start, res.err)
+	results <- res
+}
+
+// dial connects to the target, at the same address as its own connection.
+func (b *backgroundRun) dial() (redis.Conn, error) {
+	b.target.mu.Lock()
+	host, port, err := b.target.dialAddr()
+	b.target.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return dialRedis(host, port, b.target.ConnOptions, b.target.wrap)
+}
+
+// noPerm returns true if err is a redis reply saying that the user which the
+// collector authenticated as isn't allowed to run a command (by its ACL).
+func noPerm(err error) bool {
+	re, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(re), "NOPERM")
+}
+
+// deny records that the named collector isn't allowed to run for the target,
+// so that it's skipped from now on, rather than failing the same way every
+// time it runs.
+func (t *Target) deny(name string, err error) {
+	t.schedMu.Lock()
+	defer t.schedMu.Unlock()
+
+	if t.denials == nil {
+		t.denials = map[string]error{}
+	}
+
+	t.denials[name] = err
+}
+
+// denied returns why the named collector isn't allowed to run for the target,
+// or nil if it is.
+func (t *Target) denied(name string) error {
+	t.schedMu.Lock()
+	defer t.schedMu.Unlock()
+
+	return t.denials[name]
+}
+
+// close disconnects the collector, once it's finished running.
+func (b *backgroundRun) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+
+	b.dropRedirects()
+}
+
+// writeBackground writes the results of background collectors to the output
+// as they arrive, for d. This is the only place they're written, so the output
+// is never used by more than one goroutine at once.
+func writeBackground(results <-chan collected, out Output, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return
+
+		case r := <-results:
+			if r.err != nil {
+				fmt.Printf("error fetching %s metrics from %s:\n", r.collector, r.target)
+				fmt.Println(r.err)
+				continue
+			}
+
+			err := out.Write(r.t, applyTop(r.ms))
+			if err != nil {
+				fmt.Println("error writing metrics:")
+				fmt.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+// The common commands which only read data, and which write it. This is used
+// to characterize the workload, so it doesn't need to be exhaustive:
+// administrative commands, scripts and so on are neither.
+var readCommands = setOf(
+	"get", "mget", "getrange", "substr", "strlen", "getbit", "bitcount", "bitpos", "lcs",
+	"exists", "type", "ttl", "pttl", "expiretime", "pexpiretime", "keys", "scan", "randomkey", "dump", "object", "dbsize",
+	"hget", "hmget", "hgetall", "hkeys", "hvals", "hlen", "hexists", "hstrlen", "hscan", "hrandfield",
+	"lrange", "lindex", "llen", "lpos",
+	"smembers", "sismember", "smismember", "scard", "srandmember", "sscan", "sinter", "sintercard", "sunion", "sdiff",
+	"zrange", "zrangebyscore", "zrevrange", "zrevrangebyscore", "zrangebylex", "zrevrangebylex", "zscore", "zmscore",
+	"zrank", "zrevrank", "zcard", "zcount", "zlexcount", "zscan", "zrandmember", "zinter", "zintercard", "zunion", "zdiff",
+	"xrange", "xrevrange", "xread", "xlen", "xinfo", "xpending",
+	"pfcount", "geopos", "geodist", "geohash", "geosearch", "georadius_ro", "georadiusbymember_ro",
+)
+
+var writeCommands = setOf(
+	"set", "setex", "psetex", "setnx", "mset", "msetnx", "append", "setrange", "setbit", "bitfield", "bitop",
+	"incr", "incrby", "incrbyfloat", "decr", "decrby", "getset", "getdel", "getex",
+	"del", "unlink", "expire", "pexpire", "expireat", "pexpireat", "persist", "rename", "renamenx", "move", "copy", "restore", "sort",
+	"hset", "hsetnx", "hmset", "hincrby", "hincrbyfloat", "hdel",
+	"lpush", "rpush", "lpushx", "rpushx", "lpop", "rpop", "linsert", "lset", "lrem", "ltrim",
+	"rpoplpush", "lmove", "lmpop", "blpop", "brpop", "brpoplpush", "blmove", "blmpop",
+	"sadd", "srem", "spop", "smove", "sinterstore", "sunionstore", "sdiffstore",
+	"zadd", "zincrby", "zrem", "zremrangebyscore", "zremrangebyrank", "zremrangebylex", "zpopmin", "zpopmax",
+	"bzpopmin", "bzpopmax", "zmpop", "bzmpop", "zunionstore", "zinterstore", "zdiffstore", "zrangestore",
+	"xadd", "xdel", "xtrim", "xgroup", "xack", "xclaim", "xautoclaim", "xreadgroup", "xsetid",
+	"pfadd", "pfmerge", "geoadd", "georadius", "georadiusbymember", "geosearchstore",
+	"flushdb", "flushall",
+)
+
+func setOf(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[n] = true
+	}
+
+	return m
+}
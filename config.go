@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configPath = flag.String("config", "", "path to a yaml config file")
+)
+
+// Config is the schema of the config file. Options which are tagged with the
+// name of a flag set that flag, unless it was also given on the command line,
+// which takes precedence. Fields are pointers (or maps or slices), so that
+// options which are missing from the file leave the flags alone.
+//
+// The validate tag lists constraints which are checked once the file has been
+// parsed. See validateValue for the rules. The help and default tags are used
+// (instead of the flag's) by generate-config, for options without flags.
+type Config struct {
+	Interval       *time.Duration `yaml:"interval" validate:"positive" default:"10s" help:"how often to collect metrics (COLLECTD_INTERVAL takes precedence)"`
+	Host           *string        `yaml:"host" flag:"host"`
+	Port           *int           `yaml:"port" flag:"port" validate:"min=1,max=65535"`
+	Instance       *string        `yaml:"instance" flag:"instance"`
+	Socket         *string        `yaml:"socket" flag:"socket"`
+	Username       *string        `yaml:"username" flag:"username"`
+	Password       *string        `yaml:"password" flag:"password"`
+	DB             *int           `yaml:"db" flag:"db" validate:"min=0"`
+	ConnectTimeout *time.Duration `yaml:"connect_timeout" flag:"connect-timeout" validate:"min=0"`
+	Timeout        *time.Duration `yaml:"timeout" flag:"timeout" validate:"min=0"`
+	ReconnectMin   *time.Duration `yaml:"reconnect_min" flag:"reconnect-min" validate:"positive"`
+	ReconnectMax   *time.Duration `yaml:"reconnect_max" flag:"reconnect-max" validate:"positive"`
+	Prewarm        *int           `yaml:"prewarm" flag:"prewarm" validate:"min=0"`
+	Concurrency    *int           `yaml:"concurrency" flag:"concurrency" validate:"min=0"`
+	ReportAsMaster *bool          `yaml:"report_as_master" flag:"report-as-master"`
+	Output         *string        `yaml:"output" flag:"output" validate:"listof=collectd|mqtt|postgres|splunk|elasticsearch|newrelic|wavefront"`
+	OutputQueue    *int           `yaml:"output_queue" flag:"output-queue" validate:"min=0"`
+	OutputDrop     *string        `yaml:"output_drop" flag:"output-drop" validate:"oneof=oldest|newest"`
+	OutputBudget   *time.Duration `yaml:"output_budget" flag:"output-budget" validate:"min=0"`
+	MinPriority    *string        `yaml:"min_priority" flag:"min-priority" validate:"oneof=verbose|standard|core"`
+	Targets        *string        `yaml:"targets" flag:"targets"`
+	Servers        []*Target      `yaml:"servers" help:"the servers to collect from, each with the options of a -targets entry (ignored if targets is set)"`
+	StatusFile     *string        `yaml:"status_file" flag:"status-file"`
+	StatusEvery    *time.Duration `yaml:"status_every" flag:"status-every" validate:"positive"`
+	Record         *string        `yaml:"record" flag:"record"`
+	AuditLog       *string        `yaml:"audit_log" flag:"audit-log"`
+	Safe           *bool          `yaml:"safe" flag:"safe"`
+	StreamBatch    *int           `yaml:"stream_batch" flag:"stream-batch" validate:"min=0"`
+	Derived        *bool          `yaml:"derived" flag:"derived"`
+	DerivedSkip    *string        `yaml:"derived_skip" flag:"derived-skip"`
+	CounterRates   *bool          `yaml:"counter_rates" flag:"counter-rates"`
+	CPUCores       *int           `yaml:"cpu_cores" flag:"cpu-cores" validate:"min=0"`
+	CommandTimeTop *int           `yaml:"command_time_top" flag:"command-time-top" validate:"min=0"`
+	UnknownFields  *string        `yaml:"unknown_fields" flag:"unknown-fields" validate:"oneof=off|count|log"`
+	StateSets      *bool          `yaml:"state_sets" flag:"state-sets"`
+	Metadata       *bool          `yaml:"metadata" flag:"metadata"`
+
+	Filter *struct {
+		Include []string `yaml:"include" flag:"include" validate:"pattern"`
+		Exclude []string `yaml:"exclude" flag:"exclude" validate:"pattern"`
+
+		// For one of several outputs, as output:pattern.
+		OutputInclude []string `yaml:"output_include" flag:"output-include"`
+		OutputExclude []string `yaml:"output_exclude" flag:"output-exclude"`
+	} `yaml:"filter" help:"which metrics to report, by section/name pattern (see -include)"`
+
+	Rename map[string]string `yaml:"rename" flag:"rename"`
+
+	Expressions map[string]string `yaml:"expressions" flag:"expr"`
+	Top         map[string]string `yaml:"top" flag:"top"`
+	Priorities  map[string]string `yaml:"priorities" flag:"priority"`
+	Probes      map[string]string `yaml:"probes" flag:"probe"`
+	Streams     []string          `yaml:"streams" flag:"stream"`
+
+	Hook *struct {
+		Command *string        `yaml:"command" flag:"hook"`
+		Timeout *time.Duration `yaml:"timeout" flag:"hook-timeout" validate:"positive"`
+	} `yaml:"hook" help:"a program to pass the metrics through before they're written"`
+
+	Schedule map[string]string `yaml:"schedule" flag:"schedule"`
+	Block    []string          `yaml:"block" flag:"block"`
+	Profiles map[string]string `yaml:"profiles" flag:"profile"`
+
+	LatencyReset   *string `yaml:"latency_reset" flag:"latency-reset"`
+	Latency        *bool   `yaml:"latency" flag:"latency"`
+	LatencyHistory *bool   `yaml:"latency_history" flag:"latency-history"`
+	ClusterSlots   *bool   `yaml:"cluster_slots" flag:"cluster-slots"`
+	ClusterLinks   *bool   `yaml:"cluster_links" flag:"cluster-links"`
+	OutputBuffers  *bool   `yaml:"output_buffers" flag:"output-buffers"`
+	Scripts        *bool   `yaml:"scripts" flag:"scripts"`
+	MemoryStats    *bool   `yaml:"memory_stats" flag:"memory-stats"`
+	ClientList     *bool   `yaml:"client_list" flag:"client-list"`
+	DBSize         *string `yaml:"dbsize" flag:"dbsize"`
+	Utilization    *bool   `yaml:"utilization" flag:"utilization"`
+
+	PubSub *struct {
+		Enabled  *bool    `yaml:"enabled" flag:"pubsub"`
+		Channels []string `yaml:"channels" flag:"pubsub-channel"`
+	} `yaml:"pubsub" help:"how many pub/sub channels and patterns are subscribed to"`
+
+	Patterns *struct {
+		Patterns map[string]string `yaml:"patterns" flag:"pattern"`
+		Scan     *int              `yaml:"scan" flag:"pattern-scan" validate:"min=1"`
+		Samples  *int              `yaml:"samples" flag:"pattern-samples" validate:"min=0"`
+	} `yaml:"patterns" help:"how many keys match each pattern, and how much memory they use, estimated from a sample"`
+
+	TTLCoverage *struct {
+		Enabled *bool   `yaml:"enabled" flag:"ttl-coverage"`
+		Keys    *int    `yaml:"keys" flag:"ttl-coverage-keys" validate:"min=1"`
+		DBs     *string `yaml:"dbs" flag:"ttl-coverage-dbs"`
+	} `yaml:"ttl_coverage" help:"what percentage of keys have no ttl, and how long the rest are, from a sample"`
+
+	BigKeys *struct {
+		Enabled   *bool  `yaml:"enabled" flag:"big-keys"`
+		Top       *int   `yaml:"top" flag:"big-keys-top" validate:"min=1"`
+		Scan      *int   `yaml:"scan" flag:"big-keys-scan" validate:"min=1"`
+		Threshold *int64 `yaml:"threshold" flag:"big-keys-threshold" validate:"min=0"`
+	} `yaml:"big_keys" help:"the sizes of the largest keys, with a notification when one is over a threshold"`
+
+	ExpiryLag *struct {
+		Enabled *bool `yaml:"enabled" flag:"expiry-lag"`
+		Keys    *int  `yaml:"keys" flag:"expiry-lag-keys" validate:"min=1"`
+	} `yaml:"expiry_lag" help:"how late keys with ttls are expired"`
+
+	ClusterDiscover *struct {
+		Enabled  *bool          `yaml:"enabled" flag:"cluster-discover"`
+		Every    *time.Duration `yaml:"every" flag:"cluster-discover-every" validate:"positive"`
+		Instance *string        `yaml:"instance" flag:"cluster-instance" validate:"oneof=addr|id"`
+	} `yaml:"cluster_discover" help:"collect from every node of the cluster, found with CLUSTER NODES"`
+
+	SlowlogClients *struct {
+		Enabled *bool `yaml:"enabled" flag:"slowlog-clients"`
+		Top     *int  `yaml:"top" flag:"slowlog-clients-top" validate:"min=1"`
+		Len     *int  `yaml:"len" flag:"slowlog-len" validate:"min=1"`
+	} `yaml:"slowlog_clients" help:"which clients the entries of the slow log came from"`
+
+	Slowlog *struct {
+		Enabled *bool `yaml:"enabled" flag:"slowlog"`
+		Reset   *bool `yaml:"reset" flag:"slowlog-reset"`
+	} `yaml:"slowlog" help:"how many entries are added to the slow log, and how slow they are"`
+
+	Sentinel *struct {
+		Master   *string  `yaml:"master" flag:"sentinel-master"`
+		Addrs    []string `yaml:"addrs" flag:"sentinel"`
+		Password *string  `yaml:"password" flag:"sentinel-password"`
+	} `yaml:"sentinel" help:"find the master with redis sentinel, and follow it through failovers"`
+
+	TLS *struct {
+		Enabled    *bool   `yaml:"enabled" flag:"tls"`
+		CA         *string `yaml:"ca" flag:"tls-ca"`
+		Cert       *string `yaml:"cert" flag:"tls-cert"`
+		Key        *string `yaml:"key" flag:"tls-key"`
+		SkipVerify *bool   `yaml:"skip_verify" flag:"tls-skip-verify"`
+		ServerName *string `yaml:"server_name" flag:"tls-server-name"`
+	} `yaml:"tls" help:"how to connect to redis over tls"`
+
+	Runtime *struct {
+		GoMaxProcs  *int    `yaml:"gomaxprocs" flag:"gomaxprocs" validate:"min=1"`
+		MemoryLimit *string `yaml:"memory_limit" flag:"memory-limit"`
+		GCPercent   *int    `yaml:"gc_percent" flag:"gc-percent" validate:"min=-1"`
+	} `yaml:"runtime" help:"limits on the collector's own resource use"`
+
+	Admin *struct {
+		Listen  *string `yaml:"listen" flag:"admin-listen"`
+		Token   *string `yaml:"token" flag:"admin-token"`
+		Persist *bool   `yaml:"persist" flag:"admin-persist"`
+	} `yaml:"admin" help:"a local http api for managing targets at runtime"`
+
+	Collectd      *CollectdConfig      `yaml:"collectd" help:"options for the collectd output"`
+	MQTT          *MQTTConfig          `yaml:"mqtt" help:"options for the mqtt output"`
+	Postgres      *PostgresConfig      `yaml:"postgres" help:"options for the postgres output"`
+	Splunk        *SplunkConfig        `yaml:"splunk" help:"options for the splunk output"`
+	Elasticsearch *ElasticsearchConfig `yaml:"elasticsearch" help:"options for the elasticsearch output"`
+	NewRelic      *NewRelicConfig      `yaml:"newrelic" help:"options for the newrelic output"`
+	Wavefront     *WavefrontConfig     `yaml:"wavefront" help:"options for the wavefront output"`
+}
+
+type CollectdConfig struct {
+	Hostname   *string `yaml:"hostname" flag:"hostname"`
+	Plugin     *string `yaml:"plugin" flag:"plugin" validate:"plugin"`
+	MultiValue *bool   `yaml:"multi_value" flag:"multi-value"`
+	Typed      *bool   `yaml:"typed" flag:"typed"`
+
+	Sanitize *struct {
+		Char      *string `yaml:"char" flag:"sanitize-char"`
+		Lowercase *bool   `yaml:"lowercase" flag:"sanitize-lowercase"`
+	} `yaml:"sanitize" help:"how to clean up identifiers"`
+
+	Routes *struct {
+		Plugins map[string]string `yaml:"plugins" flag:"route-plugin" validate:"plugin"`
+		Types   map[string]string `yaml:"types" flag:"route-type"`
+	} `yaml:"routes" help:"report sections under other plugins or types, by section name"`
+
+	ValueTypes map[string]string `yaml:"value_types" flag:"value-type"`
+	Map        map[string]string `yaml:"map" flag:"map"`
+}
+
+type MQTTConfig struct {
+	Broker   *string `yaml:"broker" flag:"mqtt-broker"`
+	Topic    *string `yaml:"topic" flag:"mqtt-topic"`
+	QoS      *int    `yaml:"qos" flag:"mqtt-qos" validate:"min=0,max=2"`
+	ClientID *string `yaml:"client_id" flag:"mqtt-client-id"`
+	Username *string `yaml:"username" flag:"mqtt-username"`
+	Password *string `yaml:"password" flag:"mqtt-password"`
+
+	TLS *struct {
+		CA         *string `yaml:"ca" flag:"mqtt-tls-ca"`
+		Cert       *string `yaml:"cert" flag:"mqtt-tls-cert"`
+		Key        *string `yaml:"key" flag:"mqtt-tls-key"`
+		SkipVerify *bool   `yaml:"skip_verify" flag:"mqtt-tls-skip-verify"`
+	} `yaml:"tls" help:"tls is used if the broker url is ssl://"`
+}
+
+type PostgresConfig struct {
+	DSN        *string `yaml:"dsn" flag:"pg-dsn"`
+	Table      *string `yaml:"table" flag:"pg-table"`
+	Create     *bool   `yaml:"create" flag:"pg-create"`
+	Hypertable *bool   `yaml:"hypertable" flag:"pg-hypertable"`
+}
+
+type SplunkConfig struct {
+	URL           *string `yaml:"url" flag:"splunk-url"`
+	Token         *string `yaml:"token" flag:"splunk-token"`
+	Index         *string `yaml:"index" flag:"splunk-index"`
+	Sourcetype    *string `yaml:"sourcetype" flag:"splunk-sourcetype"`
+	BatchSize     *int    `yaml:"batch_size" flag:"splunk-batch-size" validate:"min=1"`
+	Retries       *int    `yaml:"retries" flag:"splunk-retries" validate:"min=0"`
+	TLSSkipVerify *bool   `yaml:"tls_skip_verify" flag:"splunk-tls-skip-verify"`
+}
+
+type ElasticsearchConfig struct {
+	URL             *string `yaml:"url" flag:"es-url"`
+	Index           *string `yaml:"index" flag:"es-index"`
+	IndexDateFormat *string `yaml:"index_date_format" flag:"es-index-date-format"`
+	Username        *string `yaml:"username" flag:"es-username"`
+	Password        *string `yaml:"password" flag:"es-password"`
+	APIKey          *string `yaml:"api_key" flag:"es-api-key"`
+	Template        *bool   `yaml:"template" flag:"es-template"`
+	Retries         *int    `yaml:"retries" flag:"es-retries" validate:"min=0"`
+	TLSSkipVerify   *bool   `yaml:"tls_skip_verify" flag:"es-tls-skip-verify"`
+}
+
+type NewRelicConfig struct {
+	URL       *string `yaml:"url" flag:"newrelic-url"`
+	APIKey    *string `yaml:"api_key" flag:"newrelic-api-key"`
+	BatchSize *int    `yaml:"batch_size" flag:"newrelic-batch-size" validate:"min=1"`
+	Retries   *int    `yaml:"retries" flag:"newrelic-retries" validate:"min=0"`
+}
+
+type WavefrontConfig struct {
+	Proxy   *string `yaml:"proxy" flag:"wavefront-proxy"`
+	URL     *string `yaml:"url" flag:"wavefront-url"`
+	Token   *string `yaml:"token" flag:"wavefront-token"`
+	Source  *string `yaml:"source" flag:"wavefront-source"`
+	Tags    *string `yaml:"tags" flag:"wavefront-tags"`
+	Retries *int    `yaml:"retries" flag:"wavefront-retries" validate:"min=0"`
+}
+
+// loadConfig parses and validates the config file at path, and applies it to
+// the flags. All of the problems found in the file are returned together, as
+// one error, each with the line it was found on.
+func loadConfig(path string) (*Config, error) {
+	c := &Config{}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the whole file into a node tree first. This catches syntax
+	// errors, and is used to find the line of each option for validation.
+	var root yaml.Node
+	err = yaml.Unmarshal(b, &root)
+	if err != nil {
+		return nil, configErrors(path, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(c)
+	if err != nil && err != io.EOF {
+		return nil, configErrors(path, err)
+	}
+
+	type lineError struct {
+		line int
+		msg  string
+	}
+
+	errs := make([]lineError, 0)
+	walkConfig(reflect.ValueOf(c).Elem(), nil, func(p []string, f reflect.StructField, v reflect.Value) {
+		rules := f.Tag.Get("validate")
+		if rules == "" {
+			return
+		}
+
+		check := func(p []string, v reflect.Value) {
+			err := validateValue(rules, v)
+			if err != nil {
+				l := configLine(&root, p)
+				errs = append(errs, lineError{l, fmt.Sprintf("%s:%d: %s: %s", path, l, strings.Join(p, "."), err)})
+			}
+		}
+
+		switch v.Kind() {
+		case reflect.Map:
+			for _, k := range v.MapKeys() {
+				check(append(p, k.String()), v.MapIndex(k))
+			}
+
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				check(append(p, strconv.Itoa(i)), v.Index(i))
+			}
+
+		default:
+			check(p, v.Elem())
+		}
+	})
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].line < errs[j].line
+		})
+
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.msg
+		}
+
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+
+	err = applyConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// applyConfig sets the flags for every option present in c, except those
+// which were given on the command line.
+func applyConfig(c *Config) error {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	walkConfig(reflect.ValueOf(c).Elem(), nil, func(p []string, f reflect.StructField, v reflect.Value) {
+		name := f.Tag.Get("flag")
+		if name == "" || given[name] || err != nil {
+			return
+		}
+
+		switch v.Kind() {
+		case reflect.Map:
+			for _, k := range v.MapKeys() {
+				err = flag.Set(name, fmt.Sprintf("%s=%s", k, v.MapIndex(k)))
+				if err != nil {
+					return
+				}
+			}
+
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				err = flag.Set(name, fmt.Sprint(v.Index(i).Interface()))
+				if err != nil {
+					return
+				}
+			}
+
+		default:
+			err = flag.Set(name, fmt.Sprint(v.Elem().Interface()))
+		}
+
+		if err != nil {
+			err = fmt.Errorf("%s: %s", strings.Join(p, "."), err)
+		}
+	})
+
+	return err
+}
+
+// walkConfig calls fn for each option of the config struct v which is present,
+// with its path of yaml keys.
+func walkConfig(v reflect.Value, path []string, fn func([]string, reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if fv.IsNil() {
+			continue
+		}
+
+		p := append(append([]string{}, path...), strings.Split(f.Tag.Get("yaml"), ",")[0])
+		if fv.Kind() == reflect.Ptr && fv.Elem().Kind() == reflect.Struct {
+			walkConfig(fv.Elem(), p, fn)
+			continue
+		}
+
+		fn(p, f, fv)
+	}
+}
+
+// validateValue checks v against a comma-separated list of rules:
+//
+//	min=N, max=N  the number must be at least/most N
+//	positive      the number (or duration) must be greater than zero
+//	oneof=a|b|c   the string must be one of the options
+//	listof=a|b|c  the string must be a comma separated list of the options
+//	plugin        the string must be a valid collectd plugin name
+//	regexp        the string must be a valid regular expression
+//	pattern       the string must be a valid filter pattern
+func validateValue(rules string, v reflect.Value) error {
+	for _, rule := range strings.Split(rules, ",") {
+		tupl := strings.SplitN(rule, "=", 2)
+		arg := ""
+		if len(tupl) == 2 {
+			arg = tupl[1]
+		}
+
+		switch tupl[0] {
+		case "min", "max":
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad rule: %s", rule)
+			}
+
+			if tupl[0] == "min" && v.Int() < n {
+				return fmt.Errorf("must be at least %d", n)
+			}
+
+			if tupl[0] == "max" && v.Int() > n {
+				return fmt.Errorf("must be at most %d", n)
+			}
+
+		case "positive":
+			if v.Int() <= 0 {
+				return fmt.Errorf("must be greater than zero")
+			}
+
+		case "oneof":
+			ok := false
+			for _, opt := range strings.Split(arg, "|") {
+				if v.String() == opt {
+					ok = true
+				}
+			}
+
+			if !ok {
+				return fmt.Errorf("must be one of: %s", strings.Replace(arg, "|", ", ", -1))
+			}
+
+		case "listof":
+			for _, s := range strings.Split(v.String(), ",") {
+				ok := false
+				for _, opt := range strings.Split(arg, "|") {
+					if strings.TrimSpace(s) == opt {
+						ok = true
+					}
+				}
+
+				if !ok {
+					return fmt.Errorf("must be one or more of: %s", strings.Replace(arg, "|", ", ", -1))
+				}
+			}
+
+		case "plugin":
+			err := checkPluginName(v.String())
+			if err != nil {
+				return err
+			}
+
+		case "regexp":
+			_, err := regexp.Compile(v.String())
+			if err != nil {
+				return err
+			}
+
+		case "pattern":
+			_, err := compilePattern(v.String())
+			if err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown rule: %s", rule)
+		}
+	}
+
+	return nil
+}
+
+// configLine returns the line on which the option at path was found, or zero
+// if it wasn't.
+func configLine(root *yaml.Node, path []string) int {
+	n := root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	for _, k := range path {
+		if n.Kind == yaml.SequenceNode {
+			i, err := strconv.Atoi(k)
+			if err != nil || i >= len(n.Content) {
+				return 0
+			}
+
+			n = n.Content[i]
+			continue
+		}
+
+		if n.Kind != yaml.MappingNode {
+			return 0
+		}
+
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == k {
+				next = n.Content[i+1]
+				break
+			}
+		}
+
+		if next == nil {
+			return 0
+		}
+		n = next
+	}
+
+	return n.Line
+}
+
+var yamlLineError = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// configErrors reformats the errors returned by the yaml package, which look
+// like "line 3: field inteval not found in type main.Config", to be prefixed
+// with the file name like the validation errors.
+func configErrors(path string, err error) error {
+	var msgs []string
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+
+	for i, msg := range msgs {
+		m := yamlLineError.FindStringSubmatch(msg)
+		if m != nil {
+			msgs[i] = fmt.Sprintf("%s:%s: %s", path, m[1], m[2])
+		} else {
+			msgs[i] = fmt.Sprintf("%s: %s", path, msg)
+		}
+	}
+
+	return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	redisUsername      = flag.String("username", "", "redis acl username (redis 6 and later; needs -password)")
+	redisPassword      = flag.String("password", "", "redis password")
+	redisDB            = flag.Int("db", 0, "redis database to select")
+	redisTLS           = flag.Bool("tls", false, "connect to redis over tls")
+	redisTLSCA         = flag.String("tls-ca", "", "path to a pem file of the ca certificates to verify redis with")
+	redisTLSCert       = flag.String("tls-cert", "", "path to a pem file of the client certificate to present to redis")
+	redisTLSKey        = flag.String("tls-key", "", "path to a pem file of the key of -tls-cert")
+	redisTLSSkipVerify = flag.Bool("tls-skip-verify", false, "don't verify the certificate of redis")
+	redisTLSServerName = flag.String("tls-server-name", "", "the server name to send redis (sni), and verify its certificate with (default the host)")
+	redisSocket        = flag.String("socket", "", "path to the unix socket of redis (instead of -host and -port)")
+	connectTimeout     = flag.Duration("connect-timeout", 0, "how long to wait to connect to redis (0 for no limit)")
+	redisTimeout       = flag.Duration("timeout", 0, "how long to wait for each reply from redis (0 for no limit)")
+)
+
+func init() {
+	flag.StringVar(redisUsername, "user", "", "the same as -username")
+}
+
+// ConnOptions are how to connect to, and authenticate with, a target. Each of
+// them defaults to its flag, so that a fleet with a mixture of (e.g.) servers
+// with passwords and servers with ACLs and TLS can be monitored, by setting
+// the options of the odd ones out in the targets file.
+type ConnOptions struct {
+	// The path of a unix socket to connect to, instead of the host and port.
+	// Unlike the rest, it doesn't default to its flag, which is only for the
+	// single target given by flags.
+	Socket string `yaml:"socket,omitempty" json:"socket,omitempty"`
+
+	Username       *string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password       *string `yaml:"password,omitempty" json:"-"` // never reported by the admin api
+	DB             *int    `yaml:"db,omitempty" json:"db,omitempty"`
+	TLS            *bool   `yaml:"tls,omitempty" json:"tls,omitempty"`
+	TLSCA          *string `yaml:"tls_ca,omitempty" json:"tls_ca,omitempty"`
+	TLSCert        *string `yaml:"tls_cert,omitempty" json:"tls_cert,omitempty"`
+	TLSKey         *string `yaml:"tls_key,omitempty" json:"tls_key,omitempty"`
+	TLSSkipVerify  *bool   `yaml:"tls_skip_verify,omitempty" json:"tls_skip_verify,omitempty"`
+	TLSServerName  *string `yaml:"tls_server_name,omitempty" json:"tls_server_name,omitempty"`
+	ConnectTimeout *string `yaml:"connect_timeout,omitempty" json:"connect_timeout,omitempty"`
+	Timeout        *string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+func stringOr(p *string, def string) string {
+	if p != nil {
+		return *p
+	}
+
+	return def
+}
+
+func intOr(p *int, def int) int {
+	if p != nil {
+		return *p
+	}
+
+	return def
+}
+
+func boolOr(p *bool, def bool) bool {
+	if p != nil {
+		return *p
+	}
+
+	return def
+}
+
+func durationOr(p *string, def time.Duration) (time.Duration, error) {
+	if p == nil {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(*p)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+
+	return d, nil
+}
+
+// check returns an error if any of the options are invalid.
+func (o ConnOptions) check() error {
+	if o.DB != nil && *o.DB < 0 {
+		return fmt.Errorf("db: must be at least 0")
+	}
+
+	if _, err := durationOr(o.ConnectTimeout, 0); err != nil {
+		return fmt.Errorf("connect_timeout: %s", err)
+	}
+
+	if _, err := durationOr(o.Timeout, 0); err != nil {
+		return fmt.Errorf("timeout: %s", err)
+	}
+
+	if stringOr(o.Username, *redisUsername) != "" && stringOr(o.Password, *redisPassword) == "" {
+		return fmt.Errorf("username: needs a password")
+	}
+
+	return nil
+}
+
+// dialOptions returns the options to dial the target with.
+func (o ConnOptions) dialOptions() ([]redis.DialOption, error) {
+	ct, err := durationOr(o.ConnectTimeout, *connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := durationOr(o.Timeout, *redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []redis.DialOption{
+		redis.DialConnectTimeout(ct),
+		redis.DialReadTimeout(t),
+		redis.DialWriteTimeout(t),
+	}
+
+	if boolOr(o.TLS, *redisTLS) {
+		tc, err := getTLSConfig(
+			stringOr(o.TLSCA, *redisTLSCA),
+			stringOr(o.TLSCert, *redisTLSCert),
+			stringOr(o.TLSKey, *redisTLSKey),
+			boolOr(o.TLSSkipVerify, *redisTLSSkipVerify))
+		if err != nil {
+			return nil, err
+		}
+
+		// If there's no server name, it's the host being dialed.
+		tc.ServerName = stringOr(o.TLSServerName, *redisTLSServerName)
+		opts = append(opts, redis.DialUseTLS(true), redis.DialTLSConfig(tc), redis.DialTLSSkipVerify(tc.InsecureSkipVerify))
+	}
+
+	return opts, nil
+}
+
+// setup authenticates the connection and selects the database, if the options
+// say to. It's done after dialing (rather than by redis.DialPassword, etc) so
+// that the commands go through the same wrappers as every other, and so that
+// an ACL username can be given.
+func (o ConnOptions) setup(conn redis.Conn) error {
+	if pass := stringOr(o.Password, *redisPassword); pass != "" {
+		args := []interface{}{pass}
+		if user := stringOr(o.Username, *redisUsername); user != "" {
+			args = []interface{}{user, pass}
+		}
+
+		_, err := conn.Do("AUTH", args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if db := intOr(o.DB, *redisDB); db != 0 {
+		_, err := conn.Do("SELECT", db)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+)
+
+// Fields of INFO which are monotonically increasing counters (until the
+// server restarts), rather than instantaneous values, by section. Other
+// sections have fields of the same names which aren't, e.g. the expiry
+// section's expired_keys is of one sample.
+var counterFields = map[string]map[string]bool{
+	"stats": {
+		"total_connections_received":                true,
+		"total_commands_processed":                  true,
+		"total_net_input_bytes":                     true,
+		"total_net_output_bytes":                    true,
+		"total_net_repl_input_bytes":                true,
+		"total_net_repl_output_bytes":               true,
+		"rejected_connections":                      true,
+		"sync_full":                                 true,
+		"sync_partial_ok":                           true,
+		"sync_partial_err":                          true,
+		"expired_keys":                              true,
+		"expired_time_cap_reached_count":            true,
+		"expire_cycle_cpu_milliseconds":             true,
+		"evicted_keys":                              true,
+		"evicted_clients":                           true,
+		"total_eviction_exceeded_time":              true,
+		"keyspace_hits":                             true,
+		"keyspace_misses":                           true,
+		"total_forks":                               true,
+		"total_active_defrag_time":                  true,
+		"active_defrag_hits":                        true,
+		"active_defrag_misses":                      true,
+		"active_defrag_key_hits":                    true,
+		"active_defrag_key_misses":                  true,
+		"total_error_replies":                       true,
+		"unexpected_error_replies":                  true,
+		"dump_payload_sanitizations":                true,
+		"total_reads_processed":                     true,
+		"total_writes_processed":                    true,
+		"io_threaded_reads_processed":               true,
+		"io_threaded_writes_processed":              true,
+		"acl_access_denied_auth":                    true,
+		"acl_access_denied_cmd":                     true,
+		"acl_access_denied_key":                     true,
+		"acl_access_denied_channel":                 true,
+		"client_query_buffer_limit_disconnections":  true,
+		"client_output_buffer_limit_disconnections": true,
+	},
+	"cpu": {
+		"used_cpu_sys":              true,
+		"used_cpu_user":             true,
+		"used_cpu_sys_children":     true,
+		"used_cpu_user_children":    true,
+		"used_cpu_sys_main_thread":  true,
+		"used_cpu_user_main_thread": true,
+	},
+	"commandstats": {
+		"calls":          true,
+		"usec":           true,
+		"rejected_calls": true,
+		"failed_calls":   true,
+	},
+	"errorstats": {
+		"count": true,
+	},
+}
+
+// isCounter returns true if the metric is a counter, as the outputs get it:
+// with -counter-rates, they get rates instead (see rates.go).
+func isCounter(m *Metric) bool {
+	return !*counterRates && counterField(m)
+}
+
+// counterField returns true if the metric is one of the counters of INFO. The
+// message counters of CLUSTER INFO are per message type, so they're matched by
+// prefix.
+func counterField(m *Metric) bool {
+	if m.Section == "cluster" && strings.HasPrefix(m.Key, "cluster_stats_messages_") {
+		return true
+	}
+
+	return counterFields[m.Section][m.Key]
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	dbsizeDBs = flag.String("dbsize", "", "report the number of keys in each of these databases with DBSIZE, as keyspace/dbN/dbsize, including the empty ones, comma separated (e.g. 0,1,2)")
+)
+
+// The keyspace section of INFO only has a line for each database with keys
+// in it, so when one is emptied (e.g. by FLUSHDB), its keys don't drop to
+// zero, they stop being reported, and graphs carry on from the last value.
+// DBSIZE is asked of each database instead, so that empty ones are reported
+// as such.
+
+// The parsed -dbsize databases, in order.
+var parsedDBSizes []int
+
+// checkDBSize parses the -dbsize databases, or returns an error if they're
+// invalid.
+func checkDBSize(s string) error {
+	dbs, err := parseDBList(s)
+	if err != nil {
+		return err
+	}
+
+	parsedDBSizes = dbs
+	return nil
+}
+
+// parseDBList parses a comma separated list of databases, returning them in
+// order, without duplicates.
+func parseDBList(s string) ([]int, error) {
+	dbs := make([]int, 0)
+	seen := map[int]bool{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		db, err := strconv.Atoi(f)
+		if err != nil || db < 0 {
+			return nil, fmt.Errorf("bad database: %q", f)
+		}
+
+		if !seen[db] {
+			seen[db] = true
+			dbs = append(dbs, db)
+		}
+	}
+
+	sort.Ints(dbs)
+	return dbs, nil
+}
+
+// dbsizeCollector reports the number of keys in each -dbsize database, in the
+// keyspace section, with the database (e.g. db0) as the prefix, like INFO's.
+var dbsizeCollector = &collector{
+	name:     "dbsize",
+	commands: []string{"SELECT", "DBSIZE"},
+	newCollect: func(tg *Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+		return func(conn redis.Conn, t time.Time) (Metrics, error) {
+			home := intOr(tg.ConnOptions.DB, *redisDB)
+			cur := home
+
+			ms := make(Metrics, 0, len(parsedDBSizes))
+			for _, db := range parsedDBSizes {
+				err := selectDB(conn, &cur, db)
+				if err != nil {
+					return nil, err
+				}
+
+				n, err := redis.Int64(conn.Do("DBSIZE"))
+				if err != nil {
+					return nil, err
+				}
+
+				ms = append(ms, &Metric{Section: "keyspace", Prefix: fmt.Sprintf("db%d", db), Key: "dbsize", Value: strconv.FormatInt(n, 10)})
+			}
+
+			err := selectDB(conn, &cur, home)
+			if err != nil {
+				return nil, err
+			}
+
+			return ms, nil
+		}
+	},
+}
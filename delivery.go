@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	outputQueue = flag.Int("output-queue", 0, "how many batches of metrics which a network output failed to send to keep, to retry with the next (0 to drop them)")
+	outputDrop  = flag.String("output-drop", "oldest", "which batch to drop when -output-queue is full: oldest or newest")
+)
+
+// The outputs which send metrics over the network can fail to (e.g. while the
+// backend is down), having retried as their own flags say. Rather than losing
+// the metrics for good, the last -output-queue batches are kept and sent again
+// (in order) before the next one. Either way, each output reports how many
+// metrics it delivered and dropped, how many batches it retried, and how many
+// are queued, so that the loss is visible. They're reported as the self
+// instance, in the output section, once per interval.
+
+// checkDelivery returns an error if the delivery flags are invalid.
+func checkDelivery() error {
+	if *outputQueue < 0 {
+		return fmt.Errorf("-output-queue must be at least 0")
+	}
+
+	if *outputDrop != "oldest" && *outputDrop != "newest" {
+		return fmt.Errorf("-output-drop must be oldest or newest")
+	}
+
+	if *outputBudget < 0 {
+		return fmt.Errorf("-output-budget must be at least 0")
+	}
+
+	return nil
+}
+
+// A batch is metrics which are waiting to be sent.
+type batch struct {
+	t  time.Time
+	ms Metrics
+}
+
+// deliveryOutput queues the batches which its output fails to send, and counts
+// what happens to them.
+type deliveryOutput struct {
+	Output
+	name string
+
+	queue []batch
+	last  time.Time
+
+	delivered int64
+	dropped   int64
+	retried   int64
+}
+
+func newDeliveryOutput(name string, out Output) *deliveryOutput {
+	return &deliveryOutput{Output: out, name: name}
+}
+
+func (o *deliveryOutput) Write(t time.Time, ms Metrics) error {
+	// The batch is copied, since the caller might reuse the slice.
+	b := batch{t, append(Metrics{}, ms...)}
+	if !t.Equal(o.last) {
+		o.last = t
+		b.ms = append(b.ms, o.metrics()...)
+	}
+
+	// Anything which is already queued goes first, so metrics are sent in
+	// order. If one of them fails, the backend's still down, so this batch
+	// joins the queue without being tried.
+	for len(o.queue) > 0 {
+		q := o.queue[0]
+		o.retried++
+
+		err := o.Output.Write(q.t, q.ms)
+		if err != nil {
+			o.enqueue(b)
+			return err
+		}
+
+		o.delivered += int64(len(q.ms))
+		o.queue = o.queue[1:]
+	}
+
+	err := o.Output.Write(b.t, b.ms)
+	if err != nil {
+		o.enqueue(b)
+		return err
+	}
+
+	o.delivered += int64(len(b.ms))
+	return nil
+}
+
+// enqueue queues a batch to retry, dropping one if the queue is full.
+func (o *deliveryOutput) enqueue(b batch) {
+	if *outputQueue == 0 {
+		o.dropped += int64(len(b.ms))
+		return
+	}
+
+	if len(o.queue) >= *outputQueue {
+		if *outputDrop == "newest" {
+			o.dropped += int64(len(b.ms))
+			return
+		}
+
+		o.dropped += int64(len(o.queue[0].ms))
+		o.queue = o.queue[1:]
+	}
+
+	o.queue = append(o.queue, b)
+}
+
+// metrics returns the output's own metrics.
+func (o *deliveryOutput) metrics() Metrics {
+	ms := make(Metrics, 0, 4)
+	add := func(key string, n int64) {
+		ms = append(ms, &Metric{
+			Instance: selfInstance,
+			Section:  "output",
+			Prefix:   o.name,
+			Key:      key,
+			Value:    strconv.FormatInt(n, 10),
+		})
+	}
+
+	add("delivered_metrics", o.delivered)
+	add("dropped_metrics", o.dropped)
+	add("retried_batches", o.retried)
+	add("queued_batches", int64(len(o.queue)))
+	return ms
+}
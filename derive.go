@@ -0,0 +1,538 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	derivedMetrics = flag.Bool("derived", true, "also report metrics derived from the fields of INFO, such as per-second rates of counters")
+	derivedSkip    = flag.String("derived-skip", "", "don't report these of the built in derived metrics, comma separated (e.g. cpu,command_time); see derive.go for the names")
+	cpuCores       = flag.Int("cpu-cores", 0, "report redis cpu utilization as a percentage of this many cores, rather than of one")
+	commandTimeTop = flag.Int("command-time-top", 10, "report the share of command execution time of this many of the most expensive commands each interval")
+)
+
+// Derived metrics are computed once the whole of a target's INFO reply has
+// been seen, from the fields it contains and (for rates) the ones of the
+// previous interval. Only the sections which derivations use are sampled, so
+// that the rest of the reply can still be streamed.
+var sampledSections = map[string]bool{
+	"server": true,
+	"stats":  true,
+	"cpu":    true,
+
+	"clients":      true,
+	"replication":  true,
+	"commandstats": true,
+
+	// For the cluster aggregates (see aggregate.go), and keyspace for its
+	// own derived metrics too.
+	"memory":   true,
+	"keyspace": true,
+}
+
+// A sample is the numeric fields of the sampled sections, as of one interval.
+// It also has the role of the server, and (once it's been derived) the rate of
+// read commands, for the cluster aggregates.
+type sample struct {
+	t      time.Time
+	values map[[2]string]float64
+
+	role  string
+	reads float64
+
+	// The section of each field, by name, or "" if there's more than one
+	// field of the name. Built the first time it's needed.
+	sections map[string]string
+}
+
+func newSample(t time.Time) *sample {
+	return &sample{t: t, values: map[[2]string]float64{}}
+}
+
+// observe records m, if it's in a sampled section. Every section is sampled if
+// there are expressions, since they can use any field.
+func (s *sample) observe(m *Metric) {
+	if !sampledSections[m.Section] && len(parsedExprs) == 0 {
+		return
+	}
+
+	f, err := m.Float()
+	if err != nil {
+		return
+	}
+
+	s.values[[2]string{m.Section, m.Name()}] = f
+}
+
+func (s *sample) value(section, name string) (float64, bool) {
+	f, ok := s.values[[2]string{section, name}]
+	return f, ok
+}
+
+// section returns the section of the field with the given name, or false if
+// there's no such field, or there's more than one.
+func (s *sample) section(name string) (string, bool) {
+	if s.sections == nil {
+		s.sections = make(map[string]string, len(s.values))
+		for k := range s.values {
+			if _, ok := s.sections[k[1]]; ok {
+				s.sections[k[1]] = ""
+			} else {
+				s.sections[k[1]] = k[0]
+			}
+		}
+	}
+
+	section := s.sections[name]
+	return section, section != ""
+}
+
+// A derivation is the context in which derived metrics are computed: the
+// current sample, and the previous one (if any).
+type derivation struct {
+	instance string
+	cur      *sample
+	prev     *sample
+	ms       Metrics
+}
+
+// derive returns the metrics derived from cur (and prev, which may be nil).
+func derive(instance string, prev, cur *sample) Metrics {
+	// The counters all start again from zero if the server restarted, so
+	// the previous sample is no use.
+	if prev != nil {
+		up, ok := cur.value("server", "uptime_in_seconds")
+		pup, pok := prev.value("server", "uptime_in_seconds")
+		if ok && pok && up < pup {
+			prev = nil
+		}
+	}
+
+	d := &derivation{instance: instance, cur: cur, prev: prev}
+	for _, dv := range derivations {
+		if !skippedDerivations[dv.name] {
+			dv.fn(d)
+		}
+	}
+
+	deriveExpressions(d)
+	return d.ms
+}
+
+func (d *derivation) value(section, name string) (float64, bool) {
+	return d.cur.value(section, name)
+}
+
+// rate returns the per-second rate at which the counter increased since the
+// previous interval. It's false if there's no previous value, or if the
+// counter went backwards.
+func (d *derivation) rate(section, name string) (float64, bool) {
+	if d.prev == nil {
+		return 0, false
+	}
+
+	dt := d.cur.t.Sub(d.prev.t).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+
+	v, ok := d.cur.value(section, name)
+	pv, pok := d.prev.value(section, name)
+	if !ok || !pok || v < pv {
+		return 0, false
+	}
+
+	return (v - pv) / dt, true
+}
+
+// emit adds a derived metric.
+func (d *derivation) emit(section, key string, f float64) {
+	d.emitPrefixed(section, "", key, f)
+}
+
+func (d *derivation) emitPrefixed(section, prefix, key string, f float64) {
+	d.ms = append(d.ms, &Metric{
+		Instance: d.instance,
+		Section:  section,
+		Prefix:   prefix,
+		Key:      key,
+		Value:    strconv.FormatFloat(f, 'f', -1, 64),
+	})
+}
+
+// The built in derivations, by name, for -derived-skip. The expressions (see
+// expr.go) are derived after them.
+var derivations = []struct {
+	name string
+	fn   func(*derivation)
+}{
+	{"net_rates", deriveNetRates},
+	{"cpu", deriveCPU},
+	{"keyspace_rates", deriveKeyspaceRates},
+	{"stats_rates", deriveStatsRates},
+	{"command_mix", deriveCommandMix},
+	{"role_reads", deriveRoleReads},
+	{"command_time", deriveCommandTime},
+	{"repl_backlog", deriveReplBacklog},
+	{"repl_lag", deriveReplLag},
+	{"clients", deriveClients},
+	{"defrag", deriveDefrag},
+	{"keyspace", deriveKeyspace},
+	{"utilization", deriveUtilization},
+}
+
+// The names of the derivations which -derived-skip skips.
+var skippedDerivations = map[string]bool{}
+
+// checkDerived parses -derived-skip, or returns an error if it names a
+// derivation which doesn't exist.
+func checkDerived(skip string) error {
+	names := map[string]bool{}
+	list := make([]string, len(derivations))
+	for i, dv := range derivations {
+		names[dv.name] = true
+		list[i] = dv.name
+	}
+
+	skipped := map[string]bool{}
+	for _, s := range strings.Split(skip, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !names[s] {
+			return fmt.Errorf("no such derivation: %s (expected one of: %s)", s, strings.Join(list, ", "))
+		}
+
+		skipped[s] = true
+	}
+
+	skippedDerivations = skipped
+	return nil
+}
+
+// deriveNetRates reports the bytes per second sent and received over the
+// interval. These are exact, unlike the server's own instantaneous_*_kbps,
+// which are sampled over a short window.
+func deriveNetRates(d *derivation) {
+	if r, ok := d.rate("stats", "total_net_input_bytes"); ok {
+		d.emit("stats", "net_input_bytes_per_sec", r)
+	}
+
+	if r, ok := d.rate("stats", "total_net_output_bytes"); ok {
+		d.emit("stats", "net_output_bytes_per_sec", r)
+	}
+}
+
+var cpuFields = []string{
+	"used_cpu_sys",
+	"used_cpu_user",
+	"used_cpu_sys_children",
+	"used_cpu_user_children",
+	"used_cpu_sys_main_thread",
+	"used_cpu_user_main_thread",
+}
+
+// deriveCPU reports the cpu utilization over the interval, from the seconds
+// of cpu time used, as a percentage of one core (or of -cpu-cores). The total
+// is of the server itself, not including its children (i.e. forks).
+func deriveCPU(d *derivation) {
+	scale := 100.0
+	if *cpuCores > 0 {
+		scale /= float64(*cpuCores)
+	}
+
+	total, n := 0.0, 0
+	for _, f := range cpuFields {
+		r, ok := d.rate("cpu", f)
+		if !ok {
+			continue
+		}
+
+		d.emit("cpu", f+"_percent", r*scale)
+		if f == "used_cpu_sys" || f == "used_cpu_user" {
+			total += r
+			n++
+		}
+	}
+
+	if n == 2 {
+		d.emit("cpu", "used_cpu_percent", total*scale)
+	}
+}
+
+// deriveKeyspaceRates reports the key lookups per second which hit and missed,
+// and the percentage of them which hit, over the interval (rather than since
+// the server started, like the counters).
+func deriveKeyspaceRates(d *derivation) {
+	hits, ok := d.rate("stats", "keyspace_hits")
+	if ok {
+		d.emit("stats", "keyspace_hits_per_sec", hits)
+	}
+
+	misses, mok := d.rate("stats", "keyspace_misses")
+	if mok {
+		d.emit("stats", "keyspace_misses_per_sec", misses)
+	}
+
+	if ok && mok && hits+misses > 0 {
+		d.emit("stats", "keyspace_hit_percent", hits/(hits+misses)*100)
+	}
+}
+
+// deriveStatsRates reports the commands processed, and the keys evicted and
+// expired, per second.
+func deriveStatsRates(d *derivation) {
+	for _, f := range [][2]string{
+		{"total_commands_processed", "commands_per_sec"},
+		{"evicted_keys", "evicted_keys_per_sec"},
+		{"expired_keys", "expired_keys_per_sec"},
+	} {
+		if r, ok := d.rate("stats", f[0]); ok {
+			d.emit("stats", f[1], r)
+		}
+	}
+}
+
+// deriveCommandMix reports what percentage of the read and write commands
+// called over the interval were reads, and writes. Other commands don't count.
+func deriveCommandMix(d *derivation) {
+	if d.prev == nil {
+		return
+	}
+
+	reads, writes := d.commandRates()
+	if reads+writes == 0 {
+		return
+	}
+
+	d.emit("stats", "read_commands_percent", reads/(reads+writes)*100)
+	d.emit("stats", "write_commands_percent", writes/(reads+writes)*100)
+}
+
+// deriveRoleReads reports the rate of read commands, named by the role of the
+// server (e.g. replica_read_commands_per_sec), so that the reads served by
+// replicas can be told apart from the ones served by masters.
+func deriveRoleReads(d *derivation) {
+	if d.prev == nil || d.cur.role == "sentinel" {
+		return
+	}
+
+	reads, _ := d.commandRates()
+	d.cur.reads = reads
+	d.emit("replication", strings.TrimPrefix(d.cur.role, "cluster_")+"_read_commands_per_sec", reads)
+}
+
+// commandRates returns the per-second rates of read and write commands called
+// over the interval.
+func (d *derivation) commandRates() (float64, float64) {
+	reads, writes := 0.0, 0.0
+	for k := range d.cur.values {
+		if k[0] != "commandstats" || !strings.HasSuffix(k[1], "/calls") {
+			continue
+		}
+
+		r, ok := d.rate(k[0], k[1])
+		if !ok {
+			continue
+		}
+
+		// Subcommands (e.g. cmdstat_client|list) are named after their
+		// parent command.
+		cmd := strings.TrimPrefix(strings.TrimSuffix(k[1], "/calls"), "cmdstat_")
+		if i := strings.IndexByte(cmd, '|'); i >= 0 {
+			cmd = cmd[:i]
+		}
+
+		switch {
+		case readCommands[cmd]:
+			reads += r
+		case writeCommands[cmd]:
+			writes += r
+		}
+	}
+
+	return reads, writes
+}
+
+// deriveCommandTime reports the percentage of the time spent executing
+// commands over the interval which went on each command, for the top
+// -command-time-top of them.
+func deriveCommandTime(d *derivation) {
+	if d.prev == nil || *commandTimeTop <= 0 {
+		return
+	}
+
+	type cmdTime struct {
+		prefix string
+		usec   float64
+	}
+
+	cmds := make([]cmdTime, 0)
+	total := 0.0
+	for k := range d.cur.values {
+		if k[0] != "commandstats" || !strings.HasSuffix(k[1], "/usec") {
+			continue
+		}
+
+		r, ok := d.rate(k[0], k[1])
+		if !ok || r == 0 {
+			continue
+		}
+
+		cmds = append(cmds, cmdTime{strings.TrimSuffix(k[1], "/usec"), r})
+		total += r
+	}
+
+	sort.Slice(cmds, func(i, j int) bool {
+		if cmds[i].usec != cmds[j].usec {
+			return cmds[i].usec > cmds[j].usec
+		}
+		return cmds[i].prefix < cmds[j].prefix
+	})
+
+	if len(cmds) > *commandTimeTop {
+		cmds = cmds[:*commandTimeTop]
+	}
+
+	for _, c := range cmds {
+		d.emitPrefixed("commandstats", c.prefix, "time_percent", c.usec/total*100)
+	}
+}
+
+// deriveReplBacklog reports how full the replication backlog is, and at the
+// rate the replication stream is growing, how many seconds of it the whole
+// backlog holds. Replicas which are disconnected for longer than that need a
+// full resync, rather than a partial one.
+func deriveReplBacklog(d *derivation) {
+	if active, _ := d.value("replication", "repl_backlog_active"); active == 0 {
+		return
+	}
+
+	size, ok := d.value("replication", "repl_backlog_size")
+	if !ok || size == 0 {
+		return
+	}
+
+	if histlen, ok := d.value("replication", "repl_backlog_histlen"); ok {
+		d.emit("replication", "repl_backlog_used_percent", histlen/size*100)
+	}
+
+	if r, ok := d.rate("replication", "master_repl_offset"); ok && r > 0 {
+		d.emit("replication", "repl_backlog_seconds", size/r)
+	}
+}
+
+// deriveReplLag reports how many bytes of the replication stream the replicas
+// are behind by. On a master, that's each replica's (from its slaveN line),
+// and the worst of them, and on a replica, how far behind its master's offset
+// it's processed.
+func deriveReplLag(d *derivation) {
+	offset, ok := d.value("replication", "master_repl_offset")
+	if !ok {
+		return
+	}
+
+	if processed, ok := d.value("replication", "slave_repl_offset"); ok {
+		d.emit("replication", "lag_bytes", math.Max(offset-processed, 0))
+		return
+	}
+
+	replicas := make([]string, 0)
+	for k := range d.cur.values {
+		if k[0] == "replication" && strings.HasSuffix(k[1], "/offset") && isReplicaKey(strings.TrimSuffix(k[1], "/offset")) {
+			replicas = append(replicas, strings.TrimSuffix(k[1], "/offset"))
+		}
+	}
+
+	if len(replicas) == 0 {
+		return
+	}
+
+	sort.Strings(replicas)
+	worst := 0.0
+	for _, r := range replicas {
+		acked, _ := d.value("replication", r+"/offset")
+		lag := math.Max(offset-acked, 0)
+		worst = math.Max(worst, lag)
+		d.emitPrefixed("replication", r, "lag_bytes", lag)
+	}
+
+	d.emit("replication", "lag_bytes", worst)
+}
+
+// deriveClients reports the percentage of the connected clients which are
+// blocked (e.g. in BLPOP), and which have client side caching (tracking)
+// enabled, and how many clients are tracking each key on average.
+func deriveClients(d *derivation) {
+	if connected, ok := d.value("clients", "connected_clients"); ok && connected > 0 {
+		if blocked, ok := d.value("clients", "blocked_clients"); ok {
+			d.emit("clients", "blocked_clients_percent", blocked/connected*100)
+		}
+
+		if tracking, ok := d.value("clients", "tracking_clients"); ok {
+			d.emit("clients", "tracking_clients_percent", tracking/connected*100)
+		}
+	}
+
+	keys, ok := d.value("stats", "tracking_total_keys")
+	items, iok := d.value("stats", "tracking_total_items")
+	if ok && iok && keys > 0 {
+		d.emit("stats", "tracking_items_per_key", items/keys)
+	}
+}
+
+var defragFields = []string{
+	"active_defrag_hits",
+	"active_defrag_misses",
+	"active_defrag_key_hits",
+	"active_defrag_key_misses",
+}
+
+// deriveDefrag reports the per-second rates of active defragmentation, and the
+// percentage of the allocations it tried to move which it could. How much cpu
+// it's using is already reported, as memory/active_defrag_running.
+func deriveDefrag(d *derivation) {
+	for _, f := range defragFields {
+		if r, ok := d.rate("stats", f); ok {
+			d.emit("stats", f+"_per_sec", r)
+		}
+	}
+
+	hits, ok := d.rate("stats", "active_defrag_hits")
+	misses, mok := d.rate("stats", "active_defrag_misses")
+	if ok && mok && hits+misses > 0 {
+		d.emit("stats", "active_defrag_hit_percent", hits/(hits+misses)*100)
+	}
+}
+
+// deriveKeyspace reports, for each database, the fraction of its keys which
+// have an expiry, and their average ttl in seconds (rather than milliseconds),
+// so that databases (and servers) can be compared directly.
+func deriveKeyspace(d *derivation) {
+	dbs := make([]string, 0)
+	for k := range d.cur.values {
+		if k[0] == "keyspace" && strings.HasSuffix(k[1], "/keys") {
+			dbs = append(dbs, strings.TrimSuffix(k[1], "/keys"))
+		}
+	}
+
+	sort.Strings(dbs)
+	for _, db := range dbs {
+		keys, _ := d.value("keyspace", db+"/keys")
+		if expires, ok := d.value("keyspace", db+"/expires"); ok && keys > 0 {
+			d.emitPrefixed("keyspace", db, "expires_ratio", expires/keys)
+		}
+
+		if ttl, ok := d.value("keyspace", db+"/avg_ttl"); ok {
+			d.emitPrefixed("keyspace", db, "avg_ttl_seconds", ttl/1000)
+		}
+	}
+}
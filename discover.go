@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	clusterDiscover      = flag.Bool("cluster-discover", false, "find every node of the cluster which the target is a node of (with CLUSTER NODES), and collect from each of them instead")
+	clusterDiscoverEvery = flag.Duration("cluster-discover-every", time.Minute, "how often to look for cluster nodes which have been added or removed")
+	clusterInstance      = flag.String("cluster-instance", "addr", "what to report the metrics of discovered cluster nodes as: addr or id")
+)
+
+// A target can be a seed, from which the nodes of its cluster are discovered.
+// Each node, including the seed itself, becomes a target of its own (with the
+// seed's options), named by its address or node id, and the seed is only
+// used to ask CLUSTER NODES (every -cluster-discover-every), so that nodes
+// which are added or removed are collected from, or stop being, without a
+// restart. The nodes are in the seed's cluster (or one named after the seed),
+// so they're aggregated too. They aren't saved to the targets file, since
+// they're discovered again at startup.
+
+// checkDiscovery returns an error if the discovery flags are invalid.
+func checkDiscovery() error {
+	if *clusterInstance != "addr" && *clusterInstance != "id" {
+		return fmt.Errorf("-cluster-instance must be addr or id")
+	}
+
+	if *clusterDiscoverEvery <= 0 {
+		return fmt.Errorf("-cluster-discover-every must be positive")
+	}
+
+	return nil
+}
+
+// Discover updates the nodes of each seed which is due, as of now. Errors are
+// logged, and the nodes left as they were.
+func (ts *Targets) Discover(now time.Time) {
+	for _, t := range ts.all() {
+		if !t.Discover || now.Before(t.discoverAt) {
+			continue
+		}
+
+		err := ts.discover(now, t)
+		if err != nil {
+			fmt.Printf("error discovering the nodes of %s:\n", t.Name)
+			fmt.Println(err)
+		}
+	}
+}
+
+// discover adds the nodes of the seed's cluster which aren't targets yet, and
+// removes the ones which have gone.
+func (ts *Targets) discover(now time.Time, seed *Target) error {
+	nodes, err := seed.clusterNodes(now)
+	if err != nil {
+		return err
+	}
+
+	cluster := seed.Cluster
+	if cluster == "" {
+		cluster = seed.Name
+	}
+
+	var clash error
+	found := map[string]bool{}
+	for _, n := range nodes {
+		if n.flags["noaddr"] || n.flags["handshake"] {
+			continue
+		}
+
+		name := n.addr
+		if *clusterInstance == "id" {
+			name = n.id
+		}
+
+		found[name] = true
+		if name == seed.Name {
+			clash = fmt.Errorf("node %s has the same name as the seed, so isn't collected (name the seed something else)", name)
+			continue
+		}
+
+		if ts.has(name) {
+			continue
+		}
+
+		host, port, err := splitHostPort(n.addr)
+		if err != nil {
+			return err
+		}
+
+		t := &Target{
+			Name:           name,
+			Host:           host,
+			Port:           port,
+			ConnOptions:    seed.ConnOptions,
+			Schedule:       seed.Schedule,
+			Block:          seed.Block,
+			ReportAsMaster: seed.ReportAsMaster,
+			Cluster:        cluster,
+			Groups:         seed.Groups,
+			Collectors:     seed.Collectors,
+			Concurrency:    seed.Concurrency,
+			ScanBudget:     seed.ScanBudget,
+			seed:           seed.Name,
+		}
+
+		t.Socket = ""
+		err = ts.Add(t)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("# found node of %s: %s\n", cluster, name)
+	}
+
+	for _, t := range ts.all() {
+		if t.seed == seed.Name && !found[t.Name] {
+			fmt.Printf("# node of %s has gone: %s\n", cluster, t.Name)
+			ts.Remove(t.Name)
+		}
+	}
+
+	return clash
+}
+
+// clusterNodes returns the nodes of the seed's cluster, connecting to it (with
+// backoff) if needed.
+func (t *Target) clusterNodes(now time.Time) ([]*clusterNode, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		if now.Before(t.retryAt) {
+			return nil, errBackingOff
+		}
+
+		conn, err := t.connect()
+		if err != nil {
+			t.retryLater(now)
+			return nil, err
+		}
+
+		t.conn = conn
+		t.backoff = 0
+	}
+
+	nodes, err := fetchClusterNodes(t.conn)
+	if err != nil {
+		t.conn.Close()
+		t.conn = nil
+		return nil, err
+	}
+
+	if nodes == nil {
+		return nil, fmt.Errorf("not a cluster node")
+	}
+
+	t.discoverAt = now.Add(*clusterDiscoverEvery)
+	return nodes, nil
+}
+
+// has returns true if there's a target with the name.
+func (ts *Targets) has(name string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, t := range ts.list {
+		if t.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,236 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+// End-to-end tests against real Redis servers, run in Docker. These are slow
+// and need a Docker daemon, so they're behind a build tag:
+//
+//	go test -tags e2e -run E2E -v .
+//
+// Each topology is tested against every major version we support, and checks
+// that the metrics we expect from it are actually emitted.
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+var e2eVersions = []string{"5", "6", "7"}
+
+// The metrics which every server should report, whatever its topology.
+var e2eCommon = []string{
+	"server/uptime_in_seconds",
+	"clients/connected_clients",
+	"memory/used_memory",
+	"persistence/rdb_changes_since_last_save",
+	"stats/total_commands_processed",
+	"stats/keyspace_hits",
+	"replication/master_repl_offset",
+	"cpu/used_cpu_sys",
+	"commandstats/cmdstat_ping/calls",
+}
+
+func TestE2EStandalone(t *testing.T) {
+	for _, v := range e2eVersions {
+		t.Run("redis"+v, func(t *testing.T) {
+			net := dockerNetwork(t)
+			addr := startRedis(t, v, net, "standalone")
+
+			// Write a key so that the keyspace section isn't empty.
+			redisCommand(t, addr, "SET", "e2e", "1")
+
+			expectMetrics(t, addr, append(e2eCommon,
+				"keyspace/db0/keys",
+				"commandstats/cmdstat_set/calls",
+			))
+		})
+	}
+}
+
+func TestE2EReplicaPair(t *testing.T) {
+	for _, v := range e2eVersions {
+		t.Run("redis"+v, func(t *testing.T) {
+			net := dockerNetwork(t)
+			master := startRedis(t, v, net, "master")
+			replica := startRedis(t, v, net, "replica", "--replicaof", containerName(net, "master"), "6379")
+
+			waitFor(t, "replica to sync", func() bool {
+				return strings.Contains(redisInfo(t, replica, "replication"), "master_link_status:up")
+			})
+
+			expectMetrics(t, master, append(e2eCommon,
+				"replication/connected_slaves",
+			))
+
+			expectMetrics(t, replica, append(e2eCommon,
+				"replication/slave_repl_offset",
+				"replication/master_last_io_seconds_ago",
+			))
+		})
+	}
+}
+
+func TestE2ECluster(t *testing.T) {
+	for _, v := range e2eVersions {
+		t.Run("redis"+v, func(t *testing.T) {
+			net := dockerNetwork(t)
+
+			addrs := make([]string, 3)
+			ips := make([]string, 3)
+			for i := range addrs {
+				name := fmt.Sprintf("node%d", i)
+				addrs[i] = startRedis(t, v, net, name, "--cluster-enabled", "yes")
+				ips[i] = docker(t, "inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", containerName(net, name)) + ":6379"
+			}
+
+			// Older versions of redis-cli can't resolve hostnames, so the
+			// cluster is created from the nodes' addresses.
+			args := append([]string{"exec", containerName(net, "node0"), "redis-cli", "--cluster", "create"}, ips...)
+			docker(t, append(args, "--cluster-yes")...)
+
+			waitFor(t, "cluster to converge", func() bool {
+				return strings.Contains(redisCommand(t, addrs[0], "CLUSTER", "INFO"), "cluster_state:ok")
+			})
+
+			for _, addr := range addrs {
+				expectMetrics(t, addr, append(e2eCommon,
+					"cluster/cluster_enabled",
+				))
+			}
+		})
+	}
+}
+
+// expectMetrics collects from the server at addr, and fails the test if any
+// of the metrics named (as section/key) weren't emitted.
+func expectMetrics(t *testing.T, addr string, names []string) {
+	host, port := splitAddr(t, addr)
+	tg := &Target{Name: addr, Host: host, Port: port}
+
+	ms, err := tg.Collect(time.Now())
+	if err != nil {
+		t.Fatalf("error collecting from %s: %s", addr, err)
+	}
+
+	got := map[string]bool{}
+	for _, m := range ms {
+		if _, err := m.Float(); err == nil {
+			got[m.Section+"/"+m.Name()] = true
+		}
+	}
+
+	for _, n := range names {
+		if !got[n] {
+			t.Errorf("%s: missing metric: %s", addr, n)
+		}
+	}
+}
+
+// dockerNetwork creates a network for the test's containers, so that they can
+// find each other by name.
+func dockerNetwork(t *testing.T) string {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker isn't installed")
+	}
+
+	name := fmt.Sprintf("cmr-e2e-%d", time.Now().UnixNano())
+	docker(t, "network", "create", name)
+	t.Cleanup(func() {
+		exec.Command("docker", "network", "rm", name).Run()
+	})
+
+	return name
+}
+
+func containerName(net, name string) string {
+	return net + "-" + name
+}
+
+// startRedis starts a Redis server of the given major version, and returns
+// the address (on localhost) it can be reached at once it's ready.
+func startRedis(t *testing.T, version, net, name string, args ...string) string {
+	cname := containerName(net, name)
+	run := []string{"run", "-d", "--rm", "--network", net, "--name", cname, "-p", "127.0.0.1::6379", "redis:" + version, "redis-server"}
+	docker(t, append(run, args...)...)
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", cname).Run()
+	})
+
+	addr := docker(t, "port", cname, "6379/tcp")
+	addr = strings.Split(addr, "\n")[0]
+
+	waitFor(t, cname+" to start", func() bool {
+		host, port := splitAddr(t, addr)
+		c, err := getRedis(host, port)
+		if err != nil {
+			return false
+		}
+
+		c.Close()
+		return true
+	})
+
+	return addr
+}
+
+func redisCommand(t *testing.T, addr string, cmd string, args ...interface{}) string {
+	host, port := splitAddr(t, addr)
+	c, err := getRedis(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	reply, err := c.Do(cmd, args...)
+	if err != nil {
+		t.Fatalf("%s: %s", cmd, err)
+	}
+
+	switch r := reply.(type) {
+	case []byte:
+		return string(r)
+	default:
+		return fmt.Sprint(r)
+	}
+}
+
+func redisInfo(t *testing.T, addr, section string) string {
+	return redisCommand(t, addr, "INFO", section)
+}
+
+func splitAddr(t *testing.T, addr string) (string, int) {
+	var port int
+	i := strings.LastIndex(addr, ":")
+	_, err := fmt.Sscanf(addr[i+1:], "%d", &port)
+	if err != nil {
+		t.Fatalf("bad address: %s", addr)
+	}
+
+	return addr[:i], port
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	deadline := time.Now().Add(60 * time.Second)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func docker(t *testing.T, args ...string) string {
+	out, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("docker %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	execStrict = flag.Bool("exec-strict", false, "conform strictly to collectd's exec plugin: write only PUTVAL and PUTNOTIF lines to stdout (logging everything else to stderr), and exit when stdin is closed, if it's a pipe")
+)
+
+// Collectd's exec plugin reads the collector's stdout as commands, and logs
+// (and ignores) lines which aren't, while the collector logs to the same
+// stdout as it reports to, like the # connected banner and errors. In strict
+// mode, those go to stderr instead, which collectd logs as errors, so that
+// nothing but PUTVAL and PUTNOTIF lines are written to stdout.
+//
+// A collector which outlives collectd would keep connecting to redis for
+// nothing. Collectd doesn't give the collector a stdin (for an Exec line, it
+// closes every fd but stdout and stderr), so there it's stopped by the first
+// write to stdout after collectd has gone, which fails and kills it with
+// SIGPIPE. When it's run with a pipe as its stdin instead (e.g. by a wrapper
+// script or a supervisor), it also exits as soon as that's closed. Any other
+// stdin is left alone, since reading it fails (or ends) right away.
+//
+// It's only a flag, not a config option, since it has to take effect before
+// the config is loaded, in case that fails.
+
+// The stdout which the collectd output writes to, which is the real stdout
+// even in strict mode.
+var stdout = os.Stdout
+
+// startExec switches to strict mode, if it's enabled. It must be called
+// before anything is written to stdout.
+func startExec() {
+	if !*execStrict {
+		return
+	}
+
+	os.Stdout = os.Stderr
+
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		return
+	}
+
+	go func() {
+		_, err := io.Copy(io.Discard, os.Stdin)
+		if err != nil {
+			return
+		}
+
+		fmt.Println("# stdin was closed, exiting")
+		os.Exit(0)
+	}()
+}
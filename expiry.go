@@ -0,0 +1,324 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	expiryLag     = flag.Bool("expiry-lag", false, "report how late keys are expired, by watching some keys with ttls and listening for their expired events (needs notify-keyspace-events to include Ex)")
+	expiryLagKeys = flag.Int("expiry-lag-keys", 20, "how many keys with ttls to watch at once, for -expiry-lag")
+)
+
+// Keys with a TTL aren't deleted the moment it runs out, but by the expire
+// cycle, which samples them in the background (unless they're accessed first).
+// When the server is busy or short of memory, the cycle falls behind, and
+// expired keys hang around, using memory. To see how far behind it is, some of
+// the keys with a TTL are sampled with SCAN, and when each is due to expire
+// is worked out from its PTTL. A subscription to the expired keyspace events
+// says when each key actually was, and the difference is the lag. Keys which
+// are overdue and haven't been expired yet count too, by how overdue they are.
+//
+// The events are only published if notify-keyspace-events includes them,
+// which the collector doesn't change. If it doesn't, there's nothing to
+// report.
+
+// The longest TTL of the keys which are watched. Keys which expire later than
+// this aren't much use, since they're not seen to expire for ages.
+const expiryHorizon = 10 * time.Minute
+
+// An expiryWatcher watches some keys of a target, and records how late they
+// were expired.
+type expiryWatcher struct {
+	target *Target
+
+	// The SCAN cursor, which is only used by collect.
+	cursor string
+
+	// Guards the fields below, which the subscriber updates: the connection
+	// which is subscribed to the expired events, when each watched key is
+	// due to expire, and how late the ones which have were.
+	mu      sync.Mutex
+	sub     redis.Conn
+	due     map[string]time.Time
+	lags    []time.Duration
+	stopped bool
+}
+
+func newExpiryWatcher(t *Target) func(conn redis.Conn, now time.Time) (Metrics, error) {
+	w := &expiryWatcher{target: t, cursor: "0", due: map[string]time.Time{}}
+
+	// This is called with t.mu held, so the subscription can be closed
+	// along with the target, and dropped when its master moves.
+	t.closers = append(t.closers, w.stop)
+	t.movers = append(t.movers, w.unsubscribe)
+	return w.collect
+}
+
+var expiryLagCollector = &collector{
+	name:       "expiry_lag",
+	commands:   []string{"CONFIG GET", "SCAN", "PTTL", "PSUBSCRIBE"},
+	newCollect: newExpiryWatcher,
+}
+
+func (w *expiryWatcher) collect(conn redis.Conn, now time.Time) (Metrics, error) {
+	cfg, err := redis.StringMap(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !expiredEventsOn(cfg["notify-keyspace-events"]) {
+		return nil, nil
+	}
+
+	w.mu.Lock()
+	subscribed := w.sub != nil
+	w.mu.Unlock()
+
+	if !subscribed {
+		err = w.subscribe()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = w.sample(conn, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.metrics(now), nil
+}
+
+// expiredEventsOn returns true if the notify-keyspace-events config publishes
+// keyevent notifications of expired keys.
+func expiredEventsOn(flags string) bool {
+	return strings.ContainsRune(flags, 'E') && strings.ContainsAny(flags, "xA")
+}
+
+// subscribe connects to the target, and starts listening for the expired
+// events of the keys being watched.
+func (w *expiryWatcher) subscribe() error {
+	// The subscription can be idle for a long time, so it mustn't time out
+	// waiting for replies.
+	o := w.target.ConnOptions
+	never := "0s"
+	o.Timeout = &never
+
+	w.target.mu.Lock()
+	host, port, err := w.target.dialAddr()
+	w.target.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialRedis(host, port, o, w.target.wrap)
+	if err != nil {
+		return err
+	}
+
+	db := intOr(o.DB, *redisDB)
+	psc := redis.PubSubConn{Conn: conn}
+	err = psc.PSubscribe(fmt.Sprintf("__keyevent@%d__:expired", db))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		conn.Close()
+		return fmt.Errorf("target was removed")
+	}
+
+	w.sub = conn
+	go w.listen(psc)
+	return nil
+}
+
+// listen records when each watched key is expired, until the subscription
+// breaks (or is closed), when it's dropped so the next run resubscribes.
+func (w *expiryWatcher) listen(psc redis.PubSubConn) {
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.PMessage:
+			w.expired(string(v.Data), time.Now())
+
+		case error:
+			psc.Close()
+			w.dropped(psc.Conn)
+			return
+		}
+	}
+}
+
+// dropped forgets the subscription, if it's still the current one.
+func (w *expiryWatcher) dropped(conn redis.Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.sub == conn {
+		w.sub = nil
+	}
+}
+
+// expired records that the key was expired at t, if it's being watched.
+func (w *expiryWatcher) expired(key string, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	due, ok := w.due[key]
+	if !ok {
+		return
+	}
+
+	delete(w.due, key)
+	lag := t.Sub(due)
+	if lag < 0 {
+		lag = 0
+	}
+
+	w.lags = append(w.lags, lag)
+}
+
+// sample finds keys with TTLs to watch, until there are -expiry-lag-keys of
+// them, and forgets the overdue ones which have gone without an event (e.g.
+// because they were deleted, or the subscription broke).
+func (w *expiryWatcher) sample(conn redis.Conn, now time.Time) error {
+	w.mu.Lock()
+	overdue := make([]string, 0)
+	for k, due := range w.due {
+		if due.Before(now) {
+			overdue = append(overdue, k)
+		}
+	}
+
+	want := *expiryLagKeys - len(w.due)
+	w.mu.Unlock()
+
+	for _, k := range overdue {
+		ms, err := redis.Int64(conn.Do("PTTL", k))
+		if err != nil {
+			return err
+		}
+
+		if ms == -2 {
+			w.mu.Lock()
+			delete(w.due, k)
+			w.mu.Unlock()
+		}
+	}
+
+	if want <= 0 {
+		return nil
+	}
+
+	reply, err := redis.Values(conn.Do("SCAN", w.cursor, "COUNT", 100))
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	_, err = redis.Scan(reply, &w.cursor, &keys)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if want == 0 {
+			break
+		}
+
+		ms, err := redis.Int64(conn.Do("PTTL", k))
+		if err != nil {
+			return err
+		}
+
+		ttl := time.Duration(ms) * time.Millisecond
+		if ms <= 0 || ttl > expiryHorizon {
+			continue
+		}
+
+		w.mu.Lock()
+		if _, ok := w.due[k]; !ok {
+			w.due[k] = now.Add(ttl)
+			want--
+		}
+		w.mu.Unlock()
+	}
+
+	return nil
+}
+
+// metrics returns how late the keys which expired since the last run were,
+// counting how overdue the ones which haven't yet are too, and starts again.
+func (w *expiryWatcher) metrics(now time.Time) Metrics {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	expired := len(w.lags)
+	lags := w.lags
+	w.lags = nil
+
+	for _, due := range w.due {
+		if due.Before(now) {
+			lags = append(lags, now.Sub(due))
+		}
+	}
+
+	max, total := time.Duration(0), time.Duration(0)
+	for _, l := range lags {
+		total += l
+		if l > max {
+			max = l
+		}
+	}
+
+	avg := time.Duration(0)
+	if len(lags) > 0 {
+		avg = total / time.Duration(len(lags))
+	}
+
+	ms := make(Metrics, 0, 5)
+	add := func(key string, f float64) {
+		ms = append(ms, &Metric{Section: "expiry", Key: key, Value: strconv.FormatFloat(f, 'f', -1, 64)})
+	}
+
+	add("lag_max_ms", float64(max)/float64(time.Millisecond))
+	add("lag_avg_ms", float64(avg)/float64(time.Millisecond))
+	add("expired_keys", float64(expired))
+	add("overdue_keys", float64(len(lags)-expired))
+	add("watched_keys", float64(len(w.due)))
+	return ms
+}
+
+// stop closes the subscription, for good.
+// unsubscribe closes the subscription, if there is one, so that the next run
+// subscribes again.
+func (w *expiryWatcher) unsubscribe() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.sub != nil {
+		w.sub.Close()
+		w.sub = nil
+	}
+}
+
+func (w *expiryWatcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	if w.sub != nil {
+		w.sub.Close()
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	expressions = mapFlag{}
+)
+
+func init() {
+	flag.Var(expressions, "expr", "report a metric computed from the fields of INFO each interval, as name=expression, e.g. 'stats/hit_ratio=keyspace_hits / (keyspace_hits + keyspace_misses)' (repeatable)")
+}
+
+// Expressions are a way to define derived metrics without changing the
+// collector. They're arithmetic (+, -, *, / and parentheses) over numbers and
+// the fields of INFO, which are named as they're reported, either with the
+// section, e.g. memory/used_memory, or without it, e.g. used_memory. The
+// section is written with no space around its slash, so a division of two
+// fields needs one, e.g. used_memory / maxmemory. A field which is in more than
+// one section (like maxmemory, which is in config too) must be given with its
+// section. The rate(field) function gives the per-second rate at which a
+// counter increased since the previous interval, like the built in derived
+// metrics.
+//
+// Each is reported as the section/key given by its name, or in the derived
+// section if the name has no section. If a field is missing, or a division is
+// by zero, it isn't reported that interval.
+
+// An expr is a parsed expression.
+type expr interface {
+	eval(d *derivation) (float64, bool)
+}
+
+type numberExpr float64
+
+// A fieldExpr is a field, and its section if it's known.
+type fieldExpr struct {
+	section string
+	name    string
+}
+
+type rateExpr fieldExpr
+
+type negExpr struct {
+	x expr
+}
+
+type binaryExpr struct {
+	op   byte
+	l, r expr
+}
+
+func (e numberExpr) eval(d *derivation) (float64, bool) {
+	return float64(e), true
+}
+
+// resolve returns the section of the field, or false if it's not known and
+// the sample doesn't have exactly one field of the name.
+func (e fieldExpr) resolve(d *derivation) (string, bool) {
+	if e.section != "" {
+		return e.section, true
+	}
+
+	return d.cur.section(e.name)
+}
+
+func (e fieldExpr) eval(d *derivation) (float64, bool) {
+	section, ok := e.resolve(d)
+	if !ok {
+		return 0, false
+	}
+
+	return d.value(section, e.name)
+}
+
+func (e rateExpr) eval(d *derivation) (float64, bool) {
+	section, ok := fieldExpr(e).resolve(d)
+	if !ok {
+		return 0, false
+	}
+
+	return d.rate(section, e.name)
+}
+
+func (e negExpr) eval(d *derivation) (float64, bool) {
+	f, ok := e.x.eval(d)
+	return -f, ok
+}
+
+func (e binaryExpr) eval(d *derivation) (float64, bool) {
+	l, ok := e.l.eval(d)
+	if !ok {
+		return 0, false
+	}
+
+	r, ok := e.r.eval(d)
+	if !ok {
+		return 0, false
+	}
+
+	switch e.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+}
+
+// parseExpr parses an expression. The grammar is:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/") factor }
+//	factor = number | field | "rate(" field ")" | "(" expr ")" | "-" factor
+//	field  = name | section "/" name
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{s: s}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.space()
+	if p.i < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.s[p.i], p.i+1)
+	}
+
+	return e, nil
+}
+
+type exprParser struct {
+	s string
+	i int
+}
+
+func (p *exprParser) space() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+// peek returns the next character, or 0 at the end.
+func (p *exprParser) peek() byte {
+	p.space()
+	if p.i < len(p.s) {
+		return p.s[p.i]
+	}
+
+	return 0
+}
+
+func (p *exprParser) expr() (expr, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.i++
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		l = binaryExpr{c, l, r}
+	}
+
+	return l, nil
+}
+
+func (p *exprParser) term() (expr, error) {
+	l, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+
+	for c := p.peek(); c == '*' || c == '/'; c = p.peek() {
+		p.i++
+		r, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		l = binaryExpr{c, l, r}
+	}
+
+	return l, nil
+}
+
+func (p *exprParser) factor() (expr, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+
+	case c == '-':
+		p.i++
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		return negExpr{x}, nil
+
+	case c == '(':
+		p.i++
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.i+1)
+		}
+
+		p.i++
+		return e, nil
+
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.i
+		for p.i < len(p.s) && (p.s[p.i] >= '0' && p.s[p.i] <= '9' || p.s[p.i] == '.') {
+			p.i++
+		}
+
+		f, err := strconv.ParseFloat(p.s[start:p.i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", p.s[start:p.i], start+1)
+		}
+
+		return numberExpr(f), nil
+
+	case isIdentChar(c):
+		start := p.i
+		name := p.ident()
+		if name != "rate" || p.peek() != '(' {
+			return p.field(name, start)
+		}
+
+		p.i++
+		p.space()
+		fstart := p.i
+		name = p.ident()
+		if name == "" {
+			return nil, fmt.Errorf("rate needs a field, at %d", start+1)
+		}
+
+		field, err := p.field(name, fstart)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.i+1)
+		}
+
+		p.i++
+		return rateExpr(field), nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", c, p.i+1)
+}
+
+// field returns the field which begins with name, which started at start. If
+// the name is followed directly by a slash and another name, it's the section,
+// and the rest (which can have slashes of its own, like keyspace/db0/keys) is
+// the field.
+func (p *exprParser) field(name string, start int) (fieldExpr, error) {
+	if p.i+1 < len(p.s) && p.s[p.i] == '/' && isIdentChar(p.s[p.i+1]) {
+		section := name
+		rest := p.i + 1
+		for p.i+1 < len(p.s) && p.s[p.i] == '/' && isIdentChar(p.s[p.i+1]) {
+			p.i++
+			p.ident()
+		}
+
+		return fieldExpr{section, p.s[rest:p.i]}, nil
+	}
+
+	sections := fieldSections[name]
+	switch len(sections) {
+	case 0:
+		return fieldExpr{name: name}, nil
+	case 1:
+		return fieldExpr{sections[0], name}, nil
+	}
+
+	return fieldExpr{}, fmt.Errorf("%s at %d is in more than one section (%s), so needs one, e.g. %s/%s", name, start+1, strings.Join(sections, ", "), sections[0], name)
+}
+
+// The sections which the fields that the collector knows the section of are
+// in, by name: the counters, and the limits, which are also in config. The
+// counters of commandstats and errorstats are left out, since their names
+// have prefixes (e.g. cmdstat_get/calls).
+var fieldSections = func() map[string][]string {
+	ix := map[string][]string{}
+	for section, fields := range counterFields {
+		if section == "commandstats" || section == "errorstats" {
+			continue
+		}
+
+		for name := range fields {
+			ix[name] = append(ix[name], section)
+		}
+	}
+
+	for _, p := range limitParams {
+		ix[p[0]] = append(ix[p[0]], "config")
+		ix[p[2]] = append(ix[p[2]], p[1])
+	}
+
+	for _, sections := range ix {
+		sort.Strings(sections)
+	}
+
+	return ix
+}()
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *exprParser) ident() string {
+	start := p.i
+	for p.i < len(p.s) && isIdentChar(p.s[p.i]) {
+		p.i++
+	}
+
+	return p.s[start:p.i]
+}
+
+// A namedExpr is an expression, and the section and key to report it as.
+type namedExpr struct {
+	section string
+	key     string
+	e       expr
+}
+
+// The parsed -expr expressions, in name order.
+var parsedExprs []namedExpr
+
+// checkExpressions parses the -expr expressions, or returns an error if any of
+// them are invalid.
+func checkExpressions(exprs map[string]string) error {
+	names := make([]string, 0, len(exprs))
+	for name := range exprs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	parsed := make([]namedExpr, 0, len(names))
+	for _, name := range names {
+		ne := namedExpr{section: "derived", key: name}
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			ne.section, ne.key = name[:i], name[i+1:]
+		}
+
+		if ne.section == "" || ne.key == "" {
+			return fmt.Errorf("%s: must be a key, or section/key", name)
+		}
+
+		e, err := parseExpr(exprs[name])
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		ne.e = e
+		parsed = append(parsed, ne)
+	}
+
+	parsedExprs = parsed
+	return nil
+}
+
+// deriveExpressions reports the -expr expressions.
+func deriveExpressions(d *derivation) {
+	for _, ne := range parsedExprs {
+		f, ok := ne.e.eval(d)
+		if ok && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			d.emit(ne.section, ne.key, f)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+var (
+	unknownFields = flag.String("unknown-fields", "off", "how to report numeric INFO fields which the collector doesn't know the type of: off, count (as collector/unknown_fields), or log (and print each one, once)")
+)
+
+// Fields of INFO which are instantaneous values (or settings, or flags), as
+// opposed to counters (see counters.go). Between them, they're the fields
+// which the collector knows the type of. New releases of Redis add fields, so
+// the unknown ones can be counted (see -unknown-fields), to show when this
+// needs updating. They're reported like any other field regardless.
+var gaugeFields = setOf(
+	// server
+	"arch_bits", "process_id", "tcp_port", "server_time_usec", "uptime_in_seconds",
+	"uptime_in_days", "hz", "configured_hz", "lru_clock", "io_threads_active",
+	"shutdown_in_milliseconds",
+
+	// clients
+	"connected_clients", "cluster_connections", "maxclients",
+	"client_recent_max_input_buffer", "client_recent_max_output_buffer",
+	"blocked_clients", "tracking_clients", "pubsub_clients", "watching_clients",
+	"clients_in_timeout_table", "total_watched_keys", "total_blocking_keys",
+	"total_blocking_keys_on_nokey",
+
+	// memory
+	"used_memory", "used_memory_rss", "used_memory_peak", "used_memory_peak_perc",
+	"used_memory_overhead", "used_memory_startup", "used_memory_dataset",
+	"used_memory_dataset_perc", "allocator_allocated", "allocator_active",
+	"allocator_resident", "total_system_memory", "used_memory_lua",
+	"used_memory_vm_eval", "used_memory_scripts_eval", "number_of_cached_scripts",
+	"number_of_functions", "number_of_libraries", "used_memory_vm_functions",
+	"used_memory_vm_total", "used_memory_functions", "used_memory_scripts",
+	"maxmemory", "allocator_frag_ratio", "allocator_frag_bytes",
+	"allocator_rss_ratio", "allocator_rss_bytes", "rss_overhead_ratio",
+	"rss_overhead_bytes", "mem_fragmentation_ratio", "mem_fragmentation_bytes",
+	"mem_not_counted_for_evict", "mem_replication_backlog",
+	"mem_total_replication_buffers", "mem_clients_slaves", "mem_clients_normal",
+	"mem_cluster_links", "mem_aof_buffer", "active_defrag_running",
+	"lazyfree_pending_objects", "lazyfreed_objects", "allocator_muzzy",
+
+	// persistence
+	"loading", "async_loading", "current_cow_peak", "current_cow_size",
+	"current_cow_size_age", "current_fork_perc", "current_save_keys_processed",
+	"current_save_keys_total", "rdb_changes_since_last_save",
+	"rdb_bgsave_in_progress", "rdb_last_save_time", "rdb_last_bgsave_time_sec",
+	"rdb_current_bgsave_time_sec", "rdb_saves", "rdb_last_cow_size",
+	"rdb_last_load_keys_expired", "rdb_last_load_keys_loaded", "aof_enabled",
+	"aof_rewrite_in_progress", "aof_rewrite_scheduled", "aof_rewrites",
+	"aof_rewrites_consecutive_failures", "aof_last_rewrite_time_sec",
+	"aof_current_rewrite_time_sec", "aof_last_cow_size", "module_fork_in_progress",
+	"module_fork_last_cow_size", "aof_current_size", "aof_base_size",
+	"aof_pending_rewrite", "aof_buffer_length", "aof_pending_bio_fsync",
+	"aof_delayed_fsync", "loading_start_time", "loading_total_bytes",
+	"loading_rdb_used_mem", "loading_loaded_bytes", "loading_loaded_perc",
+	"loading_eta_seconds",
+
+	// stats
+	"instantaneous_ops_per_sec", "instantaneous_input_kbps",
+	"instantaneous_output_kbps", "instantaneous_input_repl_kbps",
+	"instantaneous_output_repl_kbps", "expired_stale_perc", "expired_subkeys",
+	"pubsub_channels", "pubsub_patterns", "pubsubshard_channels",
+	"latest_fork_usec", "migrate_cached_sockets", "slave_expires_tracked_keys",
+	"tracking_total_keys", "tracking_total_items", "tracking_total_prefixes",
+	"current_eviction_exceeded_time", "current_active_defrag_time",
+	"reply_buffer_shrinks", "reply_buffer_expands",
+	"eventloop_cycles", "eventloop_duration_sum", "eventloop_duration_cmd_sum",
+	"instantaneous_eventloop_cycles_per_sec", "instantaneous_eventloop_duration_usec",
+
+	// replication
+	"connected_slaves", "master_failover_state", "master_repl_offset",
+	"second_repl_offset", "repl_backlog_active", "repl_backlog_size",
+	"repl_backlog_first_byte_offset", "repl_backlog_histlen", "master_port",
+	"master_last_io_seconds_ago", "master_sync_in_progress", "slave_read_repl_offset",
+	"slave_repl_offset", "slave_priority", "replica_priority", "slave_read_only",
+	"replica_read_only", "replica_announced", "master_sync_total_bytes",
+	"master_sync_read_bytes", "master_sync_left_bytes", "master_sync_perc",
+	"master_sync_last_io_seconds_ago", "master_link_down_since_seconds",
+	"offset", "lag", "online",
+
+	// cluster
+	"cluster_enabled",
+
+	// commandstats, keyspace
+	"usec_per_call", "keys", "expires", "avg_ttl", "subexpiry",
+)
+
+// checkUnknownFields returns an error if -unknown-fields isn't valid.
+func checkUnknownFields() error {
+	switch *unknownFields {
+	case "off", "count", "log":
+		return nil
+	}
+
+	return fmt.Errorf("must be one of: off, count, log")
+}
+
+// knownField returns true if the collector knows the type of the field. The
+// percentiles of latencystats are gauges, whichever ones the server is
+// configured to track (with latency-tracking-info-percentiles).
+func knownField(m *Metric) bool {
+	return counterFields[m.Section][m.Key] || gaugeFields[m.Key] || m.Section == "latencystats"
+}
+
+// The unknown fields which have been logged, so that they're only logged once
+// (per section).
+var (
+	unknownMu     sync.Mutex
+	unknownLogged = map[[2]string]bool{}
+)
+
+// observeUnknown returns true if m is a numeric field which the collector
+// doesn't know the type of, and logs it if -unknown-fields=log.
+func observeUnknown(m *Metric) bool {
+	if knownField(m) {
+		return false
+	}
+
+	if _, err := m.Float(); err != nil {
+		return false
+	}
+
+	if *unknownFields == "log" {
+		unknownMu.Lock()
+		k := [2]string{m.Section, m.Key}
+		if !unknownLogged[k] {
+			unknownLogged[k] = true
+			fmt.Printf("unknown INFO field: %s/%s\n", m.Section, m.Name())
+		}
+		unknownMu.Unlock()
+	}
+
+	return true
+}
+
+// unknownMetric returns the count of unknown fields seen in one INFO of the
+// target.
+func unknownMetric(instance string, n int) *Metric {
+	return &Metric{Instance: instance, Section: "collector", Key: "unknown_fields", Value: strconv.Itoa(n)}
+}
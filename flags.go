@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// mapFlag is a flag which can be given several times, each as key=value, and
+// collects them into a map.
+type mapFlag map[string]string
+
+func (f mapFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f mapFlag) Set(s string) error {
+	tupl := strings.SplitN(s, "=", 2)
+	if len(tupl) != 2 || tupl[0] == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+
+	f[tupl[0]] = tupl[1]
+	return nil
+}
+
+// listFlag is a flag which can be given several times, and collects the
+// values in order.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const generatedConfigHeader = `# Example configuration for collectd-more-redis. Every option is listed with
+# its default value, so this file behaves exactly like no config at all. Pass
+# it with -config; any flags given on the command line take precedence.
+`
+
+// generateConfig writes an example config file, with every option set to its
+// default and commented with its flag's usage, to the path given in args (or
+// to stdout). It's generated from the Config struct so that it can't drift.
+func generateConfig(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: %s generate-config [path]", os.Args[0])
+	}
+
+	var w io.Writer = os.Stdout
+	if len(args) == 1 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, generatedConfigHeader)
+	writeConfigStruct(bw, reflect.TypeOf(Config{}), "")
+	return bw.Flush()
+}
+
+func writeConfigStruct(w io.Writer, t reflect.Type, indent string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+
+		help, def := f.Tag.Get("help"), f.Tag.Get("default")
+		if name := f.Tag.Get("flag"); name != "" {
+			fl := flag.Lookup(name)
+			help = fmt.Sprintf("%s (-%s)", fl.Usage, name)
+			def = fl.DefValue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		fmt.Fprintln(w)
+		if help != "" {
+			fmt.Fprintf(w, "%s# %s\n", indent, help)
+		}
+
+		switch {
+		case ft.Kind() == reflect.Struct:
+			fmt.Fprintf(w, "%s%s:\n", indent, key)
+			writeConfigStruct(w, ft, indent+"  ")
+
+		case ft.Kind() == reflect.Map:
+			fmt.Fprintf(w, "%s%s: {}\n", indent, key)
+
+		case ft.Kind() == reflect.Slice:
+			fmt.Fprintf(w, "%s%s: []\n", indent, key)
+
+		case ft.Kind() == reflect.String:
+			fmt.Fprintf(w, "%s%s: %s\n", indent, key, strconv.Quote(def))
+
+		default:
+			fmt.Fprintf(w, "%s%s: %s\n", indent, key, def)
+		}
+	}
+}
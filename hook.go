@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	hookCommand = flag.String("hook", "", "a program (run with sh -c) to pass the metrics through before they're written, which can drop, change or add to them (see hook.go)")
+	hookTimeout = flag.Duration("hook-timeout", 5*time.Second, "how long to wait for -hook to reply, before giving up on it and restarting it")
+)
+
+// A hook is a program which the metrics are passed through before they're
+// written (and before the filter and rename rules are applied), for
+// transformations which the rules can't express. It's started once and kept
+// running. Each batch of metrics is written to its stdin as a line per metric:
+//
+//	instance <tab> section <tab> name <tab> value
+//
+// followed by an empty line. It replies with the metrics to write instead, in
+// the same format, also followed by an empty line. A hook which passes every
+// line through (including the empty one) changes nothing:
+//
+//	-hook 'exec cat'
+//
+// If the hook fails, or takes longer than -hook-timeout to reply, it's killed
+// (and restarted for the next batch), and the batch isn't written.
+type hookOutput struct {
+	Output
+	command string
+	timeout time.Duration
+
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+// withHook wraps out to pass the metrics through -hook, if it's set.
+func withHook(out Output) Output {
+	if *hookCommand == "" {
+		return out
+	}
+
+	return &hookOutput{Output: out, command: *hookCommand, timeout: *hookTimeout}
+}
+
+func (o *hookOutput) start() error {
+	cmd := exec.Command("sh", "-c", o.command)
+	cmd.Stderr = os.Stderr
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	o.cmd, o.in, o.out = cmd, in, bufio.NewReader(out)
+	return nil
+}
+
+func (o *hookOutput) stop() {
+	if o.cmd == nil {
+		return
+	}
+
+	o.in.Close()
+	o.cmd.Process.Kill()
+	o.cmd.Wait()
+	o.cmd = nil
+}
+
+func (o *hookOutput) Write(t time.Time, ms Metrics) error {
+	if len(ms) == 0 {
+		return nil
+	}
+
+	if o.cmd == nil {
+		err := o.start()
+		if err != nil {
+			return fmt.Errorf("starting hook: %s", err)
+		}
+	}
+
+	res, err := o.exchange(ms)
+	if err != nil {
+		o.stop()
+		return fmt.Errorf("hook: %s", err)
+	}
+
+	return o.Output.Write(t, res)
+}
+
+// exchange sends the metrics to the hook, and returns what it replies.
+func (o *hookOutput) exchange(ms Metrics) (Metrics, error) {
+	type reply struct {
+		ms  Metrics
+		err error
+	}
+
+	// The hook might be stopped, and another started, while the goroutine
+	// is still blocked on this one's pipes.
+	in, out := o.in, o.out
+
+	done := make(chan reply, 1)
+	go func() {
+		w := bufio.NewWriter(in)
+		for _, m := range ms {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Instance, m.Section, m.Name(), m.Value)
+		}
+
+		w.WriteString("\n")
+		err := w.Flush()
+		if err != nil {
+			done <- reply{err: err}
+			return
+		}
+
+		res, err := readHookReply(out)
+		done <- reply{res, err}
+	}()
+
+	timer := time.NewTimer(o.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.ms, r.err
+
+	case <-timer.C:
+		// Killing the hook unblocks the goroutine.
+		return nil, fmt.Errorf("no reply after %s", o.timeout)
+	}
+}
+
+// readHookReply reads metrics from the hook up to an empty line. The prefix of
+// a name can have slashes of its own (e.g. the key names of big_key), but the
+// key can't, so the name is split at the last one.
+func readHookReply(r *bufio.Reader) (Metrics, error) {
+	ms := make(Metrics, 0)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return ms, nil
+		}
+
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("bad line: %q", line)
+		}
+
+		m := &Metric{Instance: fields[0], Section: fields[1], Key: fields[2], Value: fields[3]}
+		if i := strings.LastIndexByte(m.Key, '/'); i >= 0 {
+			m.Prefix, m.Key = m.Key[:i], m.Key[i+1:]
+		}
+
+		ms = append(ms, m)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// httpDo sends body to url and returns the response body, retrying with
+// exponential backoff when the request fails outright or the server responds
+// with a 429 or 5xx. Any other non-2xx response is returned as an error
+// immediately, since retrying a request which the server has rejected won't
+// help.
+func httpDo(c *http.Client, method, url string, header http.Header, body []byte, retries int) ([]byte, error) {
+	backoff := time.Second
+	var res []byte
+	var err error
+
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		res, retry, err = httpDoOnce(c, method, url, header, body)
+		if err == nil || !retry {
+			return res, err
+		}
+	}
+
+	return nil, fmt.Errorf("giving up after %d retries: %s", retries, err)
+}
+
+func httpDoOnce(c *http.Client, method, url string, header http.Header, body []byte) ([]byte, bool, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		b, err := ioutil.ReadAll(res.Body)
+		return b, err != nil, err
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	err = fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(msg))
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return nil, retry, err
+}
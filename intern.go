@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+)
+
+// Section names, keys and the identifiers built from them are the same every
+// interval, so they're interned: each distinct string is allocated once and
+// shared from then on. This keeps a long-running collector's steady state
+// allocations (and so its GC work) down to the values themselves.
+//
+// The tables are bounded, and simply cleared when they fill up, in case some
+// server reports an endless variety of keys.
+const maxInterned = 1 << 16
+
+type interner struct {
+	mu      sync.Mutex
+	strings map[string]string
+	names   map[[2]string]string
+}
+
+var interned = &interner{}
+
+// intern returns the canonical copy of s. The copy is made with its own
+// backing array, so an interned substring doesn't keep its parent (e.g. a
+// whole INFO reply) alive.
+func intern(s string) string {
+	in := interned
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if c, ok := in.strings[s]; ok {
+		return c
+	}
+
+	if in.strings == nil || len(in.strings) >= maxInterned {
+		in.strings = make(map[string]string)
+	}
+
+	c := string([]byte(s))
+	in.strings[c] = c
+	return c
+}
+
+// internName returns the canonical "prefix/key" metric name.
+func internName(prefix, key string) string {
+	in := interned
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	k := [2]string{prefix, key}
+	if c, ok := in.names[k]; ok {
+		return c
+	}
+
+	if in.names == nil || len(in.names) >= maxInterned {
+		in.names = make(map[[2]string]string)
+	}
+
+	c := prefix + "/" + key
+	in.names[[2]string{c[:len(prefix)], c[len(prefix)+1:]}] = c
+	return c
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	latencyEvents  = flag.Bool("latency", false, "report the latest and worst latency of each event of the latency monitor (fork, aof-write, etc), on servers where latency-monitor-threshold is set")
+	latencyHistory = flag.Bool("latency-history", false, "with -latency, also report how many spikes of each event there were since the last run, and the worst of them (with LATENCY HISTORY)")
+	latencyReset   = flag.String("latency-reset", "", "periodically reset these latency monitor events (comma separated, or \"all\"), so that their maxima are recent; needs -safe=false, and runs hourly unless -schedule latency_reset=... says otherwise")
+)
+
+// latencyResetCollector resets the latency monitor's history of the events
+// named by -latency-reset, so that LATENCY LATEST reports the worst latency
+// since the last reset, rather than since the server started. It reports how
+// many events were reset, so that the resets show up alongside the latency
+// metrics.
+var latencyResetCollector = &collector{
+	name:     "latency_reset",
+	commands: []string{"LATENCY RESET"},
+	every:    time.Hour,
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		args := []interface{}{"RESET"}
+		if *latencyReset != "all" {
+			for _, e := range strings.Split(*latencyReset, ",") {
+				if e = strings.TrimSpace(e); e != "" {
+					args = append(args, e)
+				}
+			}
+		}
+
+		n, err := redis.Int64(conn.Do("LATENCY", args...))
+		if err != nil {
+			return nil, err
+		}
+
+		return Metrics{&Metric{
+			Section: "latency",
+			Key:     "events_reset",
+			Value:   strconv.FormatInt(n, 10),
+		}}, nil
+	},
+}
+
+// latencyCollector returns the collector which reports the latest and maximum
+// latency (in ms) of each event which the latency monitor has seen, and with
+// -latency-history, the number of spikes since the last run, and the worst of
+// them. The monitor is off unless latency-monitor-threshold is set, in which
+// case there's nothing to report, so it's not an error. If the threshold
+// can't be read (e.g. CONFIG is blocked), it's assumed to be set.
+func latencyCollector() *collector {
+	commands := []string{"CONFIG GET", "LATENCY LATEST"}
+	if *latencyHistory {
+		commands = append(commands, "LATENCY HISTORY")
+	}
+
+	return &collector{
+		name:     "latency",
+		commands: commands,
+		newCollect: func(*Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+			// The time of the latest spike of each event seen so far.
+			seen := map[string]int64{}
+
+			return func(conn redis.Conn, t time.Time) (Metrics, error) {
+				cfg, err := redis.StringMap(conn.Do("CONFIG", "GET", "latency-monitor-threshold"))
+				if _, refused := err.(redis.Error); err != nil && !refused {
+					return nil, err
+				}
+
+				if v, ok := cfg["latency-monitor-threshold"]; ok && v == "0" {
+					return nil, nil
+				}
+
+				events, err := redis.Values(conn.Do("LATENCY", "LATEST"))
+				if err != nil {
+					return nil, err
+				}
+
+				ms := make(Metrics, 0)
+				add := func(event, key string, n int64) {
+					ms = append(ms, &Metric{Section: "latency", Prefix: event, Key: key, Value: strconv.FormatInt(n, 10)})
+				}
+
+				for _, e := range events {
+					fields, err := redis.Values(e, nil)
+					if err != nil || len(fields) < 4 {
+						continue
+					}
+
+					name, _ := redis.String(fields[0], nil)
+					latest, _ := redis.Int64(fields[2], nil)
+					max, _ := redis.Int64(fields[3], nil)
+					add(name, "latest_ms", latest)
+					add(name, "max_ms", max)
+
+					if !*latencyHistory {
+						continue
+					}
+
+					spikes, worst, err := latencySpikes(conn, name, seen)
+					if err != nil {
+						return nil, err
+					}
+
+					add(name, "spikes", spikes)
+					add(name, "spikes_max_ms", worst)
+				}
+
+				return ms, nil
+			}
+		},
+	}
+}
+
+// latencySpikes returns how many spikes of the event there were since the
+// latest one in seen (or, the first time, none), and the worst of them, and
+// records the latest.
+func latencySpikes(conn redis.Conn, event string, seen map[string]int64) (int64, int64, error) {
+	samples, err := redis.Values(conn.Do("LATENCY", "HISTORY", event))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	last, started := seen[event]
+	spikes, worst, newest := int64(0), int64(0), last
+	for _, s := range samples {
+		pair, err := redis.Int64s(s, nil)
+		if err != nil || len(pair) != 2 {
+			continue
+		}
+
+		if pair[0] > newest {
+			newest = pair[0]
+		}
+
+		if started && pair[0] > last {
+			spikes++
+			if pair[1] > worst {
+				worst = pair[1]
+			}
+		}
+	}
+
+	seen[event] = newest
+	return spikes, worst, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// When a metric is missing from the backend, it could have been lost anywhere
+// between redis and the dashboard. So while the admin api is served, the
+// metrics of the latest interval of each instance are kept, as they were
+// collected (before any rules, priorities or hook had a say), for it to serve
+// at /metrics.json. Background collectors' metrics are part of the interval
+// in which they ran, so the ones which run less often are only there in the
+// intervals when they do.
+
+// latestOutput keeps the metrics of the latest interval of each instance.
+type latestOutput struct {
+	Output
+
+	mu        sync.Mutex
+	intervals map[string]*latestInterval
+}
+
+// A latestInterval is the metrics of one instance, as of one interval.
+type latestInterval struct {
+	Time    time.Time      `json:"time"`
+	Metrics []latestMetric `json:"metrics"`
+}
+
+// A latestMetric is how a metric is served by /metrics.json.
+type latestMetric struct {
+	Section string `json:"section"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+}
+
+func newLatestOutput(out Output) *latestOutput {
+	return &latestOutput{Output: out, intervals: map[string]*latestInterval{}}
+}
+
+func (o *latestOutput) Write(t time.Time, ms Metrics) error {
+	o.mu.Lock()
+	for _, m := range ms {
+		li, ok := o.intervals[m.Instance]
+		if !ok || t.After(li.Time) {
+			li = &latestInterval{Time: t}
+			o.intervals[m.Instance] = li
+		}
+
+		// Late results from an interval which has been superseded are
+		// dropped, rather than mixed into the next one.
+		if t.Before(li.Time) {
+			continue
+		}
+
+		li.Metrics = append(li.Metrics, latestMetric{m.Section, m.Name(), m.Value})
+	}
+	o.mu.Unlock()
+
+	return o.Output.Write(t, ms)
+}
+
+// latest returns the metrics of the latest interval of the instance, or if
+// instance is empty, of every instance, by name.
+func (o *latestOutput) latest(instance string) (interface{}, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if instance == "" {
+		all := make(map[string]latestInterval, len(o.intervals))
+		for name, li := range o.intervals {
+			all[name] = *li
+		}
+
+		return all, true
+	}
+
+	li, ok := o.intervals[instance]
+	if !ok {
+		return nil, false
+	}
+
+	return *li, true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var (
+	concurrency = flag.Int("concurrency", 0, "how many background collectors may run at once for each target; the rest wait their turn (0 for no limit)")
+)
+
+// The collectors are tuned for the whole fleet by the flags, but a fleet can
+// have servers which call for more (a staging server, where nobody minds the
+// load) or less (a shard whose latency matters more than its metrics). So a
+// target can give its own:
+//
+//   - collectors: which of the enabled background collectors to run for it,
+//     rather than all of them (INFO is always collected).
+//   - concurrency: how many of them may run at once, rather than -concurrency.
+//   - scan_budget: how many keys the collectors which scan the keyspace look
+//     at each run, rather than -pattern-scan, -ttl-coverage-keys and
+//     -big-keys-scan.
+
+// checkCollectors returns an error if the list names a collector which
+// doesn't exist (or isn't enabled).
+func checkCollectors(names []string) error {
+	enabled := map[string]bool{}
+	for _, c := range enabledCollectors() {
+		enabled[c.name] = true
+	}
+
+	for _, name := range names {
+		if !enabled[name] {
+			return fmt.Errorf("no such collector (or it isn't enabled): %s", name)
+		}
+	}
+
+	return nil
+}
+
+// checkLimits returns an error if the target's limits are invalid.
+func (t *Target) checkLimits() error {
+	err := checkCollectors(t.Collectors)
+	if err != nil {
+		return fmt.Errorf("collectors: %s", err)
+	}
+
+	if t.Concurrency < 0 {
+		return fmt.Errorf("concurrency: must be at least 0")
+	}
+
+	if t.ScanBudget < 0 {
+		return fmt.Errorf("scan_budget: must be at least 0")
+	}
+
+	return nil
+}
+
+// enabled returns true if the named background collector is one of the
+// target's, or it doesn't say.
+func (t *Target) enabled(name string) bool {
+	if len(t.Collectors) == 0 {
+		return true
+	}
+
+	for _, n := range t.Collectors {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// slots returns the semaphore which limits how many of the target's
+// background collectors run at once, or nil if there's no limit. It must be
+// called with t.mu held.
+func (t *Target) slots() chan struct{} {
+	n := t.Concurrency
+	if n == 0 {
+		n = *concurrency
+	}
+
+	if n <= 0 {
+		return nil
+	}
+
+	if t.running == nil {
+		t.running = make(chan struct{}, n)
+	}
+
+	return t.running
+}
+
+// scanBudget returns how many keys the target's scanning collectors should
+// look at each run, or def if it doesn't say.
+func (t *Target) scanBudget(def int) int {
+	if t.ScanBudget > 0 {
+		return t.ScanBudget
+	}
+
+	return def
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"flag"
 	"fmt"
 	"os"
@@ -14,6 +12,9 @@ import (
 )
 
 type Metric struct {
+	// The name of the target (Redis server) which the metric came from.
+	Instance string
+
 	Section string
 	Prefix  string
 	Key     string
@@ -22,103 +23,382 @@ type Metric struct {
 
 type Metrics []*Metric
 
+// Name returns the metric key, qualified by its prefix (if any).
+func (m *Metric) Name() string {
+	if m.Prefix != "" {
+		return internName(m.Prefix, m.Key)
+	}
+
+	return m.Key
+}
+
+// Float returns the metric value as a float, or an error if it's not numeric.
+func (m *Metric) Float() (float64, error) {
+	return strconv.ParseFloat(m.Value, 64)
+}
+
 const (
 	DEFAULT_INTERVAL = "10.0"
 )
 
 var (
-	redisHost = flag.String("host", "localhost", "redis hostname")
-	redisPort = flag.Int("port", 6379, "redis port")
+	redisHost   = flag.String("host", "localhost", "redis hostname")
+	redisPort   = flag.Int("port", 6379, "redis port")
+	instance    = flag.String("instance", "", "name to report the redis server as, e.g. as the collectd plugin instance (default: its address, e.g. localhost:6379)")
+	output      = flag.String("output", "collectd", "where to send metrics (collectd, mqtt, postgres, splunk, elasticsearch, newrelic, wavefront), or several of them, comma separated")
+	streamBatch = flag.Int("stream-batch", 1000, "write each server's metrics in batches of about this many as they're parsed, to bound memory use (0 for all at once)")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "generate-config" {
+		err := generateConfig(os.Args[2:])
+		if err != nil {
+			fmt.Println("error generating config:")
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	flag.Parse()
+	startExec()
+
+	cfg := &Config{}
+	if *configPath != "" {
+		var err error
+		cfg, err = loadConfig(*configPath)
+		if err != nil {
+			fmt.Println("error loading config:")
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	err := tuneRuntime()
+	if err != nil {
+		fmt.Println("error tuning runtime:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = openAuditLog()
+	if err != nil {
+		fmt.Println("error opening audit log:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkSchedule(schedule)
+	if err != nil {
+		fmt.Println("error in schedule:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkProfiles(profiles)
+	if err != nil {
+		fmt.Println("error in profiles:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkDerived(*derivedSkip)
+	if err != nil {
+		fmt.Println("error in derived metrics:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkExpressions(expressions)
+	if err != nil {
+		fmt.Println("error in expressions:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkTop(topLimits)
+	if err != nil {
+		fmt.Println("error in top limits:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkProbes(keyProbes)
+	if err != nil {
+		fmt.Println("error in key probes:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkDBSize(*dbsizeDBs)
+	if err != nil {
+		fmt.Println("error in -dbsize:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	interval, err := getInterval()
+	err = checkPatterns(keyPatterns)
+	if err != nil {
+		fmt.Println("error in key patterns:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkPriorities(*minPriority, priorityOverride)
+	if err != nil {
+		fmt.Println("error in priorities:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkTTLCoverage()
+	if err != nil {
+		fmt.Println("error in ttl coverage:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkBigKeys()
+	if err != nil {
+		fmt.Println("error in -big-keys:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkUnknownFields()
+	if err != nil {
+		fmt.Println("error in -unknown-fields:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkDiscovery()
+	if err != nil {
+		fmt.Println("error in cluster discovery:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkReconnect()
+	if err != nil {
+		fmt.Println("error in reconnect backoff:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkBlock(blocked)
+	if err != nil {
+		fmt.Println("error in block list:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = checkSafe(requiredCommands())
+	if err != nil {
+		fmt.Println("error checking commands:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	interval, err := getInterval(cfg)
 	if err != nil {
 		fmt.Println("error parsing interval:")
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	conn, err := getRedis(*redisHost, *redisPort)
+	if flag.Arg(0) == "selftest" {
+		targets, err := getTargets(cfg.Servers)
+		if err != nil {
+			fmt.Println("error loading targets:")
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		selfTest(os.Stdout, targets, interval, enabledCollectors())
+		return
+	}
+
+	err = checkDelivery()
 	if err != nil {
-		fmt.Println("error connecting to redis:")
+		fmt.Println("error initializing output:")
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	for {
-		t := time.Now()
+	out, err := getOutput(*output, interval)
+	if err != nil {
+		fmt.Println("error initializing output:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-		ms, err := fetchMetrics(conn)
+	targets, err := getTargets(cfg.Servers)
+	if err != nil {
+		fmt.Println("error loading targets:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *adminListen != "" {
+		latest := newLatestOutput(out)
+		out = latest
+
+		err = serveAdmin(*adminListen, targets, latest)
 		if err != nil {
-			fmt.Println("error fetching metrics:")
+			fmt.Println("error starting admin api:")
 			fmt.Println(err)
 			os.Exit(1)
 		}
+	}
 
-		for _, m := range ms {
+	if *replayDir != "" {
+		times, err := replayTimes(*replayDir)
+		if err != nil {
+			fmt.Println("error loading recordings:")
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
-			var pk string
-			if m.Prefix != "" {
-				pk = fmt.Sprintf("%s/%s", m.Prefix, m.Key)
-			} else {
-				pk = m.Key
-			}
+		for _, t := range times {
+			collect(t, targets, out)
+		}
 
-			f, err := strconv.ParseFloat(m.Value, 64)
-			if err != nil {
-				continue
-			}
+		return
+	}
+
+	if *syntheticCycles > 0 {
+		runSynthetic(targets, interval, out)
+		return
+	}
+
+	if *prewarm > 0 {
+		targets.Discover(time.Now())
+		targets.Prewarm(time.Now(), *prewarm)
+	}
 
-			fmt.Printf("PUTVAL redis/%s/%s interval=%f %d:%f\n", m.Section, pk, interval.Seconds(), t.Unix(), f)
+	cs := enabledCollectors()
+	results := make(chan collected, 64)
+	for {
+		now := time.Now()
+		targets.Discover(now)
+		runScheduled(now, targets, out, interval, cs, results)
+		writeStatus(now, targets, cs)
+		writeBackground(results, out, time.Until(targets.NextRun(now.Add(interval))))
+	}
+}
+
+// collect fetches the metrics from every active target, and writes them to
+// the output as they're parsed.
+func collect(t time.Time, targets *Targets, out Output) {
+	for _, tg := range targets.Active() {
+		collectTarget(t, tg, out)
+	}
+}
+
+// collectTarget fetches the metrics from one target, and writes them to the
+// output as they're parsed. Errors are logged when the target first fails,
+// rather than every time until it recovers.
+func collectTarget(t time.Time, tg *Target, out Output) {
+	start := time.Now()
+	err := tg.Stream(t, *streamBatch, func(ms Metrics) error {
+		err := out.Write(t, ms)
+		if err != nil {
+			fmt.Println("error writing metrics:")
+			fmt.Println(err)
 		}
-		time.Sleep(interval)
+
+		return nil
+	})
+
+	tg.ran("info", start, err)
+	if tg.failed(err) {
+		fmt.Printf("error fetching metrics from %s (retrying until it's back):\n", tg.Name)
+		fmt.Println(err)
 	}
 }
 
-func fetchMetrics(conn redis.Conn) (Metrics, error) {
+// fetchInfo returns the reply to INFO ALL.
+func fetchInfo(conn redis.Conn) ([]byte, error) {
+	return redis.Bytes(conn.Do("INFO", "ALL"))
+}
+
+// parseInfo parses the reply to INFO into metrics.
+func parseInfo(blob []byte) (Metrics, error) {
 	ms := make([]*Metric, 0)
-	s := ""
+	err := streamInfo(blob, 0, func(b Metrics) error {
+		ms = append(ms, b...)
+		return nil
+	})
 
-	reply, err := conn.Do("INFO", "ALL")
-	if err != nil {
-		return ms, err
-	}
+	return ms, err
+}
 
-	blob, err := redis.Bytes(reply, err)
-	if err != nil {
-		return ms, err
-	}
+// streamInfo parses the reply to INFO, and passes the metrics to fn in
+// batches of about size (or all at once, if size is zero), so that only one
+// batch needs to be in memory at a time. The batch is reused, so fn mustn't
+// keep it.
+//
+// Batches are only cut where they can't split the fields of a value group:
+// before a section header, or before a line of prefixed fields (which each
+// form their own group).
+func streamInfo(blob []byte, size int, fn func(Metrics) error) error {
+	ms := make([]*Metric, 0, size)
+	s := ""
 
-	scanner := bufio.NewScanner(bytes.NewReader(blob))
-	for scanner.Scan() {
-		line := scanner.Text()
+	// The whole reply is converted to a string once, and sliced into lines,
+	// so the values share its memory rather than each being allocated.
+	text := string(blob)
+	for len(text) > 0 {
+		line := text
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			line, text = text[:i], text[i+1:]
+		} else {
+			text = ""
+		}
+
+		line = strings.TrimSuffix(line, "\r")
 
 		// Ignore Empty lines
 		if len(line) == 0 {
 			continue
 		}
 
+		header := strings.HasPrefix(line, "#")
+		if size > 0 && len(ms) >= size && (header || isKVLine(line)) {
+			err := fn(ms)
+			if err != nil {
+				return err
+			}
+
+			ms = ms[:0]
+		}
+
 		// Update the section name?
-		if strings.HasPrefix(line, "#") {
-			s = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "#")))
+		if header {
+			s = intern(strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "#"))))
 			continue
 		}
 
 		// Add all metrics found on the line
-		mms, _ := parseLine(s, line)
-		for _, m := range mms {
-			ms = append(ms, m)
-		}
+		ms, _ = appendLine(ms, s, line)
 	}
 
-	return ms, nil
+	if len(ms) == 0 {
+		return nil
+	}
+
+	return fn(ms)
 }
 
 func parseLine(section, line string) (Metrics, error) {
-	ms := make([]*Metric, 0)
+	return appendLine(make([]*Metric, 0), section, line)
+}
+
+// appendLine parses a line of the given section, and appends the metrics it
+// contains to ms.
+func appendLine(ms Metrics, section, line string) (Metrics, error) {
 
 	// Comment lines aren't an error, but they're not a metric either.
 	if strings.HasPrefix(line, "#") {
@@ -126,21 +406,20 @@ func parseLine(section, line string) (Metrics, error) {
 	}
 
 	// All other lines should be in k:v form.
-	parts := strings.SplitN(line, ":", 2)
-	if len(parts) != 2 {
-		return ms, fmt.Errorf("expected 2 parts, got %d", len(parts))
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return ms, fmt.Errorf("expected 2 parts, got 1")
 	}
 
-	k := parts[0]
-	v := parts[1]
+	k := intern(line[:i])
+	v := line[i+1:]
 
-	// The commandstats section is in a special format:
-	// cmdstat_XXX: calls=XXX,usec=XXX,usec_per_call=XXX
-	if strings.HasPrefix(k, "cmdstat_") || strings.HasPrefix(k, "db") {
-		for _, m := range parseKVLine(section, k, v) {
-			ms = append(ms, m)
-		}
-	} else {
+	switch {
+	case isKVLine(k):
+		ms = appendKVLine(ms, section, k, v)
+	case isReplicaKey(k):
+		ms = appendReplicaLine(ms, section, k, v)
+	default:
 		ms = append(ms, &Metric{
 			Section: section,
 			Key:     k,
@@ -151,42 +430,144 @@ func parseLine(section, line string) (Metrics, error) {
 	return ms, nil
 }
 
+// isKVLine returns true if the line (or key) is in the format of the
+// commandstats, errorstats, latencystats and keyspace sections:
+// cmdstat_XXX: calls=XXX,usec=XXX,usec_per_call=XXX
+// errorstat_XXX: count=XXX
+// latencystat_XXX: p50=XXX,p99=XXX,p99.9=XXX
+func isKVLine(line string) bool {
+	for _, p := range kvPrefixes {
+		if strings.HasPrefix(line, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The prefixes of the keys of lines in the key=value format.
+var kvPrefixes = []string{"cmdstat_", "errorstat_", "latencystat_", "db"}
+
 func parseKVLine(section, prefix, v string) Metrics {
-	ms := make(Metrics, 0)
+	return appendKVLine(make(Metrics, 0), section, prefix, v)
+}
 
-	parts := strings.Split(v, ",")
-	for _, pair := range parts {
+func appendKVLine(ms Metrics, section, prefix, v string) Metrics {
+	for len(v) > 0 {
+		pair := v
+		if i := strings.IndexByte(v, ','); i >= 0 {
+			pair, v = v[:i], v[i+1:]
+		} else {
+			v = ""
+		}
 
-		tupl := strings.SplitN(pair, "=", 2)
-		if len(tupl) != 2 {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
 			continue
 		}
 
+		// Percentiles like p99.9 are named p99_9, since dots separate the
+		// parts of names to many backends.
+		k := pair[:i]
+		if strings.IndexByte(k, '.') >= 0 {
+			k = strings.Replace(k, ".", "_", -1)
+		}
+
 		ms = append(ms, &Metric{
 			Section: section,
 			Prefix:  prefix,
-			Key:     tupl[0],
-			Value:   tupl[1],
+			Key:     intern(k),
+			Value:   pair[i+1:],
 		})
 	}
 
 	return ms
 }
 
+// isReplicaKey returns true if the key is one of a master's replicas, in the
+// replication section, e.g. slave0.
+func isReplicaKey(k string) bool {
+	if !strings.HasPrefix(k, "slave") || len(k) == len("slave") {
+		return false
+	}
+
+	for _, c := range k[len("slave"):] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// appendReplicaLine parses the line of one of a master's replicas, e.g.
+// slave0:ip=10.0.0.2,port=6379,state=online,offset=1000,lag=0, and appends
+// its offset and lag (in seconds) to ms, prefixed with the key, and whether
+// it's online (1) or still syncing (0). The rest of its fields say which
+// replica it is, rather than how it's doing, so they're left out.
+func appendReplicaLine(ms Metrics, section, k, v string) Metrics {
+	for _, m := range appendKVLine(make(Metrics, 0, 5), section, k, v) {
+		switch m.Key {
+		case "offset", "lag":
+			ms = append(ms, m)
+
+		case "state":
+			m.Key = "online"
+			if m.Value == "online" {
+				m.Value = "1"
+			} else {
+				m.Value = "0"
+			}
+
+			ms = append(ms, m)
+		}
+	}
+
+	return ms
+}
+
 func getRedis(host string, port int) (redis.Conn, error) {
-	addr := fmt.Sprintf("%s:%d", host, port)
+	return dialRedis(host, port, ConnOptions{}, nil)
+}
+
+// dialRedis connects to the server (with the options, which default to the
+// flags) at host and port, or its unix socket, authenticates, and checks that
+// it's responding. If wrap isn't nil, the connection is wrapped with it before
+// the rest, so that those commands go through the wrapper too.
+func dialRedis(host string, port int, o ConnOptions, wrap func(redis.Conn) redis.Conn) (redis.Conn, error) {
+	network, addr := "tcp", fmt.Sprintf("%s:%d", host, port)
+	if o.Socket != "" {
+		network, addr = "unix", o.Socket
+	}
 
-	r, err := redis.Dial("tcp", addr)
+	opts, err := o.dialOptions()
 	if err != nil {
 		return nil, err
 	}
 
+	r, err := redis.Dial(network, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if wrap != nil {
+		r = wrap(r)
+	}
+
+	err = o.setup(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
 	s, err := redis.String(r.Do("PING"))
 	if err != nil {
+		r.Close()
 		return nil, err
 	}
 
 	if s != "PONG" {
+		r.Close()
 		return nil, fmt.Errorf("expected PONG, got %v", s)
 	}
 
@@ -194,9 +575,13 @@ func getRedis(host string, port int) (redis.Conn, error) {
 	return r, nil
 }
 
-func getInterval() (time.Duration, error) {
+func getInterval(cfg *Config) (time.Duration, error) {
 	s := os.Getenv("COLLECTD_INTERVAL")
 	if s == "" {
+		if cfg.Interval != nil {
+			return *cfg.Interval, nil
+		}
+
 		s = DEFAULT_INTERVAL
 	}
 
@@ -205,6 +590,10 @@ func getInterval() (time.Duration, error) {
 		return 0, err
 	}
 
+	if f <= 0 {
+		return 0, fmt.Errorf("interval must be positive, got %s", s)
+	}
+
 	// Convert seconds float to nanoseconds int.
 	return time.Duration(int(f * 1000000000)), nil
 }
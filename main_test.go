@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// The seed corpus for the INFO fuzzers: a real(istic) INFO ALL reply, plus
+// the interesting corners of the format. Anything which the fuzzer finds is
+// saved under testdata/fuzz, and is run by plain `go test` from then on.
+func infoSeeds(f *testing.F) {
+	blob, err := ioutil.ReadFile("testdata/info.txt")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(blob)
+	f.Add([]byte(""))
+	f.Add([]byte("#\r\n:\r\n"))
+	f.Add([]byte("# Keyspace\r\ndb0:keys=1,expires=0,avg_ttl=0\r\ndb1:\r\n"))
+	f.Add([]byte("# Commandstats\r\ncmdstat_get:calls=1,,=,usec\r\ncmdstat_:=\r\n"))
+	f.Add([]byte("# Memory\r\nused_memory:" + strings.Repeat("9", 100000) + "\r\n"))
+	f.Add([]byte("used_memory")) // truncated, no section
+	f.Add([]byte("\xff\xfe#\xc0\r\n\xc0:\xc0=\xc0\r\n"))
+}
+
+func FuzzParseInfo(f *testing.F) {
+	infoSeeds(f)
+
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		ms, err := parseInfo(blob)
+		if err != nil {
+			t.Fatalf("parseInfo returned error: %s", err)
+		}
+
+		for _, m := range ms {
+			if strings.Contains(m.Section+m.Prefix+m.Key+m.Value, "\n") {
+				t.Fatalf("metric contains newline: %#v", m)
+			}
+		}
+
+		// Parsing is stateless, so must give the same result every time.
+		again, _ := parseInfo(blob)
+		if !reflect.DeepEqual(ms, again) {
+			t.Fatalf("parseInfo isn't deterministic")
+		}
+	})
+}
+
+func FuzzParseLine(f *testing.F) {
+	infoSeeds(f)
+
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		for _, line := range bytes.Split(blob, []byte("\r\n")) {
+			ms, err := parseLine("section", string(line))
+			if err != nil {
+				continue
+			}
+
+			for _, m := range ms {
+				if m.Section != "section" {
+					t.Fatalf("metric has wrong section: %#v", m)
+				}
+			}
+		}
+	})
+}
+
+func FuzzParseKVLine(f *testing.F) {
+	f.Add("cmdstat_get", "calls=1,usec=2,usec_per_call=2.00")
+	f.Add("db0", "keys=1,expires=0,avg_ttl=0")
+	f.Add("", "")
+	f.Add("x", ",,=,==,a=b=c")
+
+	f.Fuzz(func(t *testing.T, prefix, v string) {
+		ms := parseKVLine("section", prefix, v)
+
+		if len(ms) > strings.Count(v, ",")+1 {
+			t.Fatalf("got %d metrics from %d pairs", len(ms), strings.Count(v, ",")+1)
+		}
+
+		for _, m := range ms {
+			if m.Prefix != prefix {
+				t.Fatalf("metric has wrong prefix: %#v", m)
+			}
+
+			if strings.Contains(m.Key, "=") || strings.Contains(m.Key, ",") {
+				t.Fatalf("metric key wasn't split: %#v", m)
+			}
+		}
+	})
+}
+
+func TestParseInfo(t *testing.T) {
+	blob, err := ioutil.ReadFile("testdata/info.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := parseInfo(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"memory/used_memory":                 "1048576",
+		"commandstats/cmdstat_get/calls":     "100",
+		"errorstats/errorstat_ERR/count":     "3",
+		"latencystats/latencystat_get/p99_9": "0.015",
+		"keyspace/db0/expires":               "2",
+		"replication/slave0/offset":          "1000",
+		"replication/slave0/online":          "1",
+	}
+
+	for _, m := range ms {
+		k := m.Section + "/" + m.Name()
+		if v, ok := want[k]; ok {
+			if m.Value != v {
+				t.Errorf("%s: got %q, want %q", k, m.Value, v)
+			}
+			delete(want, k)
+		}
+	}
+
+	for k := range want {
+		t.Errorf("missing metric: %s", k)
+	}
+}
+
+func TestCollectdOutput(t *testing.T) {
+	defer func(host string) { *collectdHostname = host }(*collectdHostname)
+	*collectdHostname = "cache01"
+
+	out, err := newCollectdOutput(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	out.w = &buf
+
+	err = out.Write(time.Unix(1700000000, 0), Metrics{
+		{Instance: "localhost:6379", Section: "memory", Key: "used_memory", Value: "1048576"},
+		{Instance: "localhost:6379", Section: "commandstats", Prefix: "cmdstat_get", Key: "calls", Value: "100"},
+		{Instance: "localhost:6379", Section: "server", Key: "redis_mode", Value: "standalone"},
+		{Instance: "localhost:6379", Section: "metadata", Key: "redis_version", Value: "7.2.4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "PUTVAL cache01/redis-localhost_6379/memory-used_memory interval=10.000000 1700000000:1048576.000000\n" +
+		"PUTVAL cache01/redis-localhost_6379/commandstats-cmdstat_get_calls interval=10.000000 1700000000:100.000000\n" +
+		"PUTNOTIF host=cache01 plugin=redis plugin_instance=localhost_6379 type=metadata severity=okay time=1700000000 message=\"redis_version=7.2.4\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDiscoverSeedNode(t *testing.T) {
+	nodes := "07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-8191\n" +
+		"e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:7001@17001 master - 0 0 2 connected 8192-16383\n"
+
+	seed := &Target{Host: "127.0.0.1", Port: 7000, Discover: true}
+	seed.dial = func() (redis.Conn, error) {
+		return &replayConn{entries: []replayEntry{{cmdline: "CLUSTER NODES", reply: []byte(nodes)}}}, nil
+	}
+
+	ts := &Targets{}
+	err := ts.Add(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ts.discover(time.Now(), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, tg := range ts.Active() {
+		got[tg.Name] = tg.Cluster
+	}
+
+	want := map[string]string{
+		"127.0.0.1:7000": "127.0.0.1:7000",
+		"127.0.0.1:7001": "127.0.0.1:7000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got nodes %v, want %v", got, want)
+	}
+}
+
+func TestClusterPatterns(t *testing.T) {
+	defer func(ps []keyPattern) { parsedPatterns = ps }(parsedPatterns)
+	parsedPatterns = []keyPattern{{name: "sessions"}}
+
+	sampler := func(name string, examined, dbsize, matched int64) (*patternSampler, *patternCounts) {
+		s := &patternSampler{target: &Target{Name: name, Cluster: "c", seed: "seed:c"}}
+		c := &patternCounts{examined: examined, dbsize: dbsize, samples: map[string]patternSample{
+			"sessions": {matched: matched, sized: matched, bytes: matched * 100},
+		}}
+		return s, c
+	}
+
+	a, ac := sampler("a", 100, 1000, 10)
+	b, bc := sampler("b", 100, 3000, 50)
+	defer a.forget()
+	defer b.forget()
+
+	if c := b.share(bc); c == nil {
+		t.Fatal("b didn't report the cluster's estimates")
+	}
+	if c := a.share(ac); c == nil {
+		t.Fatal("a didn't report the cluster's estimates")
+	}
+	if c := b.share(bc); c != nil {
+		t.Fatal("b reported the cluster's estimates along with a")
+	}
+
+	got := map[string]string{}
+	for _, m := range a.share(ac).metrics() {
+		got[m.Key] = m.Value
+	}
+
+	want := map[string]string{
+		"estimated_keys":  "1200",
+		"avg_bytes":       "100",
+		"estimated_bytes": "120000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		err string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", "missing ) at 7"},
+		{"1 2", "unexpected '2' at 3"},
+		{"1..2", `bad number "1..2" at 1`},
+		{"rate()", "rate needs a field, at 1"},
+		{"rate(used_memory", "missing ) at 17"},
+		{"used_memory % 2", "unexpected '%' at 13"},
+		{"maxmemory", "maxmemory at 1 is in more than one section (config, memory), so needs one, e.g. config/maxmemory"},
+		{"rate(expired_keys) + 1 / maxclients", "maxclients at 26 is in more than one section (clients, config), so needs one, e.g. clients/maxclients"},
+	} {
+		_, err := parseExpr(tc.s)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("parseExpr(%q): got error %v, want %q", tc.s, err, tc.err)
+		}
+	}
+}
+
+func TestEvalExpr(t *testing.T) {
+	prev := newSample(time.Unix(0, 0))
+	prev.values[[2]string{"stats", "total_commands_processed"}] = 100
+
+	cur := newSample(time.Unix(10, 0))
+	for k, v := range map[[2]string]float64{
+		{"stats", "total_commands_processed"}: 600,
+		{"stats", "keyspace_hits"}:            3,
+		{"stats", "keyspace_misses"}:          1,
+		{"memory", "used_memory"}:             50,
+		{"memory", "maxmemory"}:               200,
+		{"config", "maxmemory"}:               100,
+		{"keyspace", "db0/keys"}:              7,
+		{"a", "twice"}:                        1,
+		{"b", "twice"}:                        2,
+	} {
+		cur.values[k] = v
+	}
+
+	for _, tc := range []struct {
+		s  string
+		f  float64
+		ok bool
+	}{
+		{"1 + 2 * 3", 7, true},
+		{"(1 + 2) * 3", 9, true},
+		{"10 - 4 - 3", 3, true},
+		{"12 / 3 / 2", 2, true},
+		{"-2 * -3", 6, true},
+		{"keyspace_hits / (keyspace_hits + keyspace_misses) * 100", 75, true},
+		{"used_memory / config/maxmemory * 100", 50, true},
+		{"used_memory / memory/maxmemory * 100", 25, true},
+		{"keyspace/db0/keys * 2", 14, true},
+		{"rate(total_commands_processed)", 50, true},
+		{"rate(stats/total_commands_processed)", 50, true},
+		{"1 / 0", 0, false},
+		{"used_memory / (keyspace_hits - 3)", 0, false},
+		{"no_such_field + 1", 0, false},
+		{"memory/keyspace_hits", 0, false},
+		{"rate(used_memory)", 0, false},
+		{"twice", 0, false},
+		{"b/twice", 2, true},
+	} {
+		e, err := parseExpr(tc.s)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %s", tc.s, err)
+			continue
+		}
+
+		f, ok := e.eval(&derivation{cur: cur, prev: prev})
+		if f != tc.f && tc.ok || ok != tc.ok {
+			t.Errorf("%q: got %v, %v, want %v, %v", tc.s, f, ok, tc.f, tc.ok)
+		}
+	}
+}
+
+func TestDeriveStatsRates(t *testing.T) {
+	defer func(skipped map[string]bool) { skippedDerivations = skipped }(skippedDerivations)
+	err := checkDerived("net_rates, cpu, keyspace_rates, command_mix, role_reads, command_time, repl_backlog, repl_lag, clients, defrag, keyspace, utilization")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(t time.Time, uptime, commands, evicted float64) *sample {
+		s := newSample(t)
+		s.values[[2]string{"server", "uptime_in_seconds"}] = uptime
+		s.values[[2]string{"stats", "total_commands_processed"}] = commands
+		s.values[[2]string{"stats", "evicted_keys"}] = evicted
+		return s
+	}
+
+	for _, tc := range []struct {
+		name      string
+		prev, cur *sample
+		want      map[string]string
+	}{
+		{
+			"rates",
+			at(time.Unix(0, 0), 100, 1000, 10),
+			at(time.Unix(10, 0), 110, 2000, 15),
+			map[string]string{"commands_per_sec": "100", "evicted_keys_per_sec": "0.5"},
+		},
+		{
+			"first interval",
+			nil,
+			at(time.Unix(10, 0), 110, 2000, 15),
+			map[string]string{},
+		},
+		{
+			"restarted",
+			at(time.Unix(0, 0), 100, 1000, 10),
+			at(time.Unix(10, 0), 5, 20, 0),
+			map[string]string{},
+		},
+	} {
+		got := map[string]string{}
+		for _, m := range derive("i", tc.prev, tc.cur) {
+			got[m.Key] = m.Value
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if err := checkDerived("cpu,nope"); err == nil {
+		t.Errorf("checkDerived accepted a derivation which doesn't exist")
+	}
+}
+
+// captureOutput keeps the names of the metrics written to it.
+type captureOutput struct {
+	names []string
+}
+
+func (o *captureOutput) Write(t time.Time, ms Metrics) error {
+	for _, m := range ms {
+		o.names = append(o.names, m.Section+"/"+m.Name())
+	}
+
+	return nil
+}
+
+func TestRules(t *testing.T) {
+	metrics := func() Metrics {
+		return Metrics{
+			{Section: "commandstats", Prefix: "cmdstat_get", Key: "calls", Value: "1"},
+			{Section: "commandstats", Prefix: "cmdstat_set", Key: "calls", Value: "1"},
+			{Section: "commandstats", Prefix: "cmdstat_del", Key: "calls", Value: "1"},
+			{Section: "memory", Key: "used_memory", Value: "1"},
+			{Section: "memory", Key: "used_memory_rss", Value: "1"},
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		inc, exc []string
+		ren      map[string]string
+		want     []string
+	}{
+		{
+			"include",
+			[]string{"memory/used_memory"}, nil, nil,
+			[]string{"memory/used_memory"},
+		},
+		{
+			"exclude",
+			nil, []string{"commandstats/*"}, nil,
+			[]string{"memory/used_memory", "memory/used_memory_rss"},
+		},
+		{
+			"exclude with exceptions",
+			[]string{"commandstats/cmdstat_[gs]et/*"}, []string{"commandstats/*"}, nil,
+			[]string{"commandstats/cmdstat_get/calls", "commandstats/cmdstat_set/calls", "memory/used_memory", "memory/used_memory_rss"},
+		},
+		{
+			"negated class",
+			nil, []string{"commandstats/cmdstat_[!g]??/calls"}, nil,
+			[]string{"commandstats/cmdstat_get/calls", "memory/used_memory", "memory/used_memory_rss"},
+		},
+		{
+			"regexp",
+			[]string{"~memory/used_memory(_rss)?"}, nil, nil,
+			[]string{"memory/used_memory", "memory/used_memory_rss"},
+		},
+		{
+			"rename",
+			[]string{"memory/*"}, nil, map[string]string{"memory/used_memory": "rss", "~memory/used_memory_(.*)": "mem/$1"},
+			[]string{"memory/rss", "mem/rss"},
+		},
+	} {
+		capture := &captureOutput{}
+		out, err := newRulesOutput(capture, tc.inc, tc.exc, tc.ren)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		// Twice, so that the cached results are used too.
+		for i := 0; i < 2; i++ {
+			capture.names = nil
+			out.Write(time.Unix(0, 0), metrics())
+			if !reflect.DeepEqual(capture.names, tc.want) {
+				t.Errorf("%s: got %v, want %v", tc.name, capture.names, tc.want)
+			}
+		}
+	}
+
+	for _, s := range []string{"commandstats/cmdstat_[get", "~(", "memory/[]"} {
+		if _, err := compilePattern(s); err == nil {
+			t.Errorf("compilePattern(%q) didn't fail", s)
+		}
+	}
+}
+
+func TestParseCollectdMap(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want collectdMap
+		err  bool
+	}{
+		{"bytes", collectdMap{typ: "bytes"}, false},
+		{"memory-used", collectdMap{typ: "memory", instance: "used"}, false},
+		{"cache_ratio-hit-rate", collectdMap{typ: "cache_ratio", instance: "hit-rate"}, false},
+		{"-used", collectdMap{}, true},
+		{"mem ory", collectdMap{}, true},
+		{"memory-us/ed", collectdMap{}, true},
+	} {
+		cm, err := parseCollectdMap(tc.s)
+		if (err != nil) != tc.err || err == nil && cm != tc.want {
+			t.Errorf("parseCollectdMap(%q): got %+v, %v", tc.s, cm, err)
+		}
+	}
+}
+
+func TestReadHookReply(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("i\tbig_key\tusers/42/bytes\t100\ni\tmemory\tused_memory\t5\n\n"))
+	ms, err := readHookReply(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Metrics{
+		{Instance: "i", Section: "big_key", Prefix: "users/42", Key: "bytes", Value: "100"},
+		{Instance: "i", Section: "memory", Key: "used_memory", Value: "5"},
+	}
+	if !reflect.DeepEqual(ms, want) {
+		t.Errorf("got %+v, want %+v", ms, want)
+	}
+}
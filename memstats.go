@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	memoryStats = flag.Bool("memory-stats", false, "report the breakdown of memory use from MEMORY STATS (per database overhead, client buffers, etc), which INFO leaves out")
+)
+
+// memoryStatsCollector reports the reply to MEMORY STATS, in the memory_stats
+// section. The reply is a list of names and values, some of which (e.g. db.0)
+// are lists of their own, which are reported with the outer name as their
+// prefix. Dots in the names are replaced with underscores, e.g.
+// clients.normal becomes clients_normal, and db.0 overhead.hashtable.main
+// becomes db_0_overhead_hashtable_main.
+var memoryStatsCollector = &collector{
+	name:     "memory_stats",
+	commands: []string{"MEMORY STATS"},
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		reply, err := conn.Do("MEMORY", "STATS")
+		if err != nil {
+			return nil, err
+		}
+
+		return flattenMemoryStats(reply, "")
+	},
+}
+
+// flattenMemoryStats returns the numeric values of the (part of the) reply
+// to MEMORY STATS, with the prefix.
+func flattenMemoryStats(reply interface{}, prefix string) (Metrics, error) {
+	vs, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make(Metrics, 0, len(vs)/2)
+	for i := 0; i+1 < len(vs); i += 2 {
+		name, err := redis.String(vs[i], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		name = strings.Replace(name, ".", "_", -1)
+		switch v := vs[i+1].(type) {
+		case []interface{}:
+			nested, err := flattenMemoryStats(v, name)
+			if err != nil {
+				return nil, err
+			}
+
+			ms = append(ms, nested...)
+
+		case int64:
+			ms = append(ms, &Metric{Section: "memory_stats", Prefix: prefix, Key: name, Value: strconv.FormatInt(v, 10)})
+
+		case []byte:
+			// Some values, like dataset.percentage, are floats, which
+			// are sent as strings.
+			ms = append(ms, &Metric{Section: "memory_stats", Prefix: prefix, Key: name, Value: string(v)})
+		}
+	}
+
+	return ms, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+var (
+	reportMetadata = flag.Bool("metadata", true, "report what version of redis each server runs (and how it's configured) when it's first collected, and whenever it changes, as a collectd notification")
+)
+
+// The fields of INFO (and CLUSTER INFO) which describe a server, rather than
+// measure it. They're reported once, in the metadata section, when a target
+// is first collected, and again whenever any of them change (e.g. after an
+// upgrade, or a restart, which changes the run id), so that changes in
+// behaviour can be lined up with them. The collectd output reports them as a
+// notification, rather than as values.
+var metadataFields = [][2]string{
+	{"server", "redis_version"},
+	{"server", "os"},
+	{"server", "arch_bits"},
+	{"server", "run_id"},
+	{"memory", "maxmemory_policy"},
+	{"cluster", "cluster_my_epoch"},
+}
+
+// metadataField returns the name of the metadata field which m is, if it is one.
+func metadataField(m *Metric) (string, bool) {
+	for _, f := range metadataFields {
+		if m.Section == f[0] && m.Key == f[1] && m.Prefix == "" {
+			return f[1], true
+		}
+	}
+
+	return "", false
+}
+
+// observeMetadata records m in meta, if it's a metadata field.
+func observeMetadata(meta map[string]string, m *Metric) {
+	if k, ok := metadataField(m); ok {
+		meta[k] = m.Value
+	}
+}
+
+// metadataChanged returns the metadata as metrics, if it's not the same as
+// prev, or nil if it is.
+func metadataChanged(instance string, prev, cur map[string]string) Metrics {
+	same := len(prev) == len(cur)
+	for k, v := range cur {
+		if prev[k] != v {
+			same = false
+		}
+	}
+
+	if same {
+		return nil
+	}
+
+	ms := make(Metrics, 0, len(cur))
+	for _, f := range metadataFields {
+		if v, ok := cur[f[1]]; ok {
+			ms = append(ms, &Metric{Instance: instance, Section: "metadata", Key: f[1], Value: v})
+		}
+	}
+
+	return ms
+}
+
+// notifMessage returns the metrics of a notification as the message of a collectd
+// notification: space separated key=value pairs, quoted for the exec plugin.
+func notifMessage(ms Metrics) string {
+	pairs := make([]string, len(ms))
+	for i, m := range ms {
+		pairs[i] = m.Key + "=" + m.Value
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(strings.Join(pairs, " ")) + `"`
+}
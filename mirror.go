@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+)
+
+var (
+	reportAsMaster = flag.Bool("report-as-master", false, "report the metrics of a replica as its master's (by the master's address), so that it can be collected from instead of the master")
+)
+
+// To take the load of monitoring off a master, its metrics can be collected
+// from one of its replicas instead, and reported as the master. The master's
+// address is found from the replica's INFO each interval, so if the replica is
+// repointed at another master, its metrics follow. If it's promoted, it's
+// reported as itself, since it's the master now.
+
+// mirrors returns true if the target's metrics should be reported as its
+// master's.
+func (t *Target) mirrors() bool {
+	return t.ReportAsMaster || *reportAsMaster
+}
+
+// mirroredInstance returns the instance which the metrics of a replica named
+// name should be reported as, given all of the metrics of its INFO: the
+// address of its master, or its own name if it's not a replica.
+func mirroredInstance(name string, ms Metrics) string {
+	role, host, port := "", "", ""
+	for _, m := range ms {
+		if m.Section != "replication" {
+			continue
+		}
+
+		switch m.Key {
+		case "role":
+			role = m.Value
+		case "master_host":
+			host = m.Value
+		case "master_port":
+			port = m.Value
+		}
+	}
+
+	if role != "slave" || host == "" {
+		return name
+	}
+
+	return host + ":" + port
+}
+
+// instance returns the instance which the target's metrics are reported as,
+// as of the last interval.
+func (t *Target) instance() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.reportAs != "" {
+		return t.reportAs
+	}
+
+	return t.Name
+}
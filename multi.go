@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	outputIncludes = listFlag{}
+	outputExcludes = listFlag{}
+)
+
+func init() {
+	flag.Var(&outputIncludes, "output-include", "like -include, but only for one of several outputs, as output:pattern (repeatable)")
+	flag.Var(&outputExcludes, "output-exclude", "like -exclude, but only for one of several outputs, as output:pattern (repeatable)")
+}
+
+// The metrics can be sent to several outputs at once (e.g. while migrating
+// from one monitoring system to another), each of which can have filters of
+// its own, applied after the ones for every output. Renames apply to every
+// output alike.
+
+// multiOutput writes the metrics to each of its outputs.
+type multiOutput struct {
+	names   []string
+	outputs []Output
+}
+
+// newMultiOutput returns the named outputs, each wrapped to apply its own
+// filters. If there's only one, and it has no filters, it's returned as it is.
+func newMultiOutput(names []string, interval time.Duration) (Output, error) {
+	inc, err := splitOutputRules(names, outputIncludes)
+	if err != nil {
+		return nil, err
+	}
+
+	exc, err := splitOutputRules(names, outputExcludes)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &multiOutput{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		for _, n := range o.names {
+			if n == name {
+				return nil, fmt.Errorf("duplicate output: %s", name)
+			}
+		}
+
+		out, err := newOutput(name, interval)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only collectd doesn't send metrics over the network.
+		if name != "collectd" {
+			out = newDeliveryOutput(name, out)
+		}
+
+		if *outputBudget > 0 {
+			out = newShedOutput(name, out, *outputBudget)
+		}
+
+		out, err = newRulesOutput(out, inc[name], exc[name], nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		o.names = append(o.names, name)
+		o.outputs = append(o.outputs, out)
+	}
+
+	if len(o.outputs) == 1 {
+		return o.outputs[0], nil
+	}
+
+	return o, nil
+}
+
+// splitOutputRules splits rules given as output:pattern by output, checking
+// that each output is one of names.
+func splitOutputRules(names []string, rules []string) (map[string][]string, error) {
+	known := map[string]bool{}
+	for _, name := range names {
+		known[strings.TrimSpace(name)] = true
+	}
+
+	split := map[string][]string{}
+	for _, r := range rules {
+		tupl := strings.SplitN(r, ":", 2)
+		if len(tupl) != 2 {
+			return nil, fmt.Errorf("expected output:pattern, got %q", r)
+		}
+
+		if !known[tupl[0]] {
+			return nil, fmt.Errorf("not one of the outputs: %s", tupl[0])
+		}
+
+		split[tupl[0]] = append(split[tupl[0]], tupl[1])
+	}
+
+	return split, nil
+}
+
+// Write writes the metrics to every output, even if some of them fail.
+func (o *multiOutput) Write(t time.Time, ms Metrics) error {
+	errs := make([]string, 0)
+	for i, out := range o.outputs {
+		err := out.Write(t, ms)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", o.names[i], err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Output is a destination for the metrics collected each interval.
+type Output interface {
+	Write(t time.Time, ms Metrics) error
+}
+
+// getOutput returns the named outputs (which are comma separated), wrapped to
+// apply any filter and rename rules, and before them, the hook, and before
+// that, the conversion of counters to rates.
+func getOutput(names string, interval time.Duration) (Output, error) {
+	out, err := newMultiOutput(strings.Split(names, ","), interval)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err = withRules(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return withRates(withHook(withPriority(out))), nil
+}
+
+func newOutput(name string, interval time.Duration) (Output, error) {
+	switch name {
+	case "collectd":
+		return newCollectdOutput(interval)
+
+	case "mqtt":
+		return newMQTTOutput(interval)
+
+	case "postgres":
+		return newPostgresOutput()
+
+	case "splunk":
+		return newSplunkOutput(interval)
+
+	case "elasticsearch":
+		return newElasticsearchOutput(interval)
+
+	case "newrelic":
+		return newNewRelicOutput(interval)
+
+	case "wavefront":
+		return newWavefrontOutput(interval)
+	}
+
+	return nil, fmt.Errorf("unknown output: %s", name)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	collectdHostname  = flag.String("hostname", "", "collectd host name to report metrics as (default: COLLECTD_HOSTNAME, or the system's hostname)")
+	collectdPlugin    = flag.String("plugin", "redis", "collectd plugin name to report metrics as")
+	sanitizeChar      = flag.String("sanitize-char", "_", "replace characters which aren't allowed in collectd identifiers with this")
+	sanitizeLowercase = flag.Bool("sanitize-lowercase", false, "lowercase collectd identifiers")
+	multiValue        = flag.Bool("multi-value", false, "report related fields together as multi-value collectd types (see types.db)")
+	typedValues       = flag.Bool("typed", false, "report each metric as the collectd type for its kind of value (gauge, or derive for counters), with its section in the type instance, rather than as a type named after its section")
+
+	// Overrides of the plugin and type names for specific sections, from
+	// the -route-plugin and -route-type flags.
+	routePlugins = mapFlag{}
+	routeTypes   = mapFlag{}
+
+	// Overrides of the types of specific metrics, with -typed, from the
+	// -value-type flag.
+	valueTypes = mapFlag{}
+
+	// The types and type instances of specific metrics, from the -map flag.
+	metricMaps = mapFlag{}
+)
+
+func init() {
+	flag.Var(routePlugins, "route-plugin", "report a section under a different collectd plugin, as section=plugin (repeatable)")
+	flag.Var(routeTypes, "route-type", "report a section as a different collectd type, as section=type (repeatable)")
+	flag.Var(valueTypes, "value-type", "with -typed, report a metric as a different collectd type, as section/name=type, or name=type for that field in any section, e.g. memory/used_memory=bytes (repeatable)")
+	flag.Var(metricMaps, "map", "report a metric as a specific collectd type and type instance, as section/name=type-instance (or just type), or name=type-instance for that field in any section, e.g. memory/used_memory=memory (repeatable)")
+}
+
+// collectdOutput writes metrics to stdout in the collectd exec plugin's
+// plain text protocol, where each value is identified as
+// host/plugin-instance/type-instance. The host is the one collectd gives the
+// exec plugin (in COLLECTD_HOSTNAME), unless it's overridden with -hostname.
+// Each target is a plugin instance, named by -instance (or the target's name
+// in the -targets file), which defaults to its address. By default each
+// section is reported as a type of the plugin, with the metric as the type
+// instance, e.g. cache01/redis-localhost_6379/memory-used_memory, but
+// sections can be routed to other plugins and types. The notification
+// sections (like metadata) are reported as notifications, of the section's
+// type, instead.
+//
+// A type named after a section has to be defined (in collectd's TypesDB) to be
+// stored, and says nothing of whether its values are gauges or counters, so
+// counters are graphed as ever-rising lines. With -typed, each metric is
+// reported as a type of collectd's own types.db instead: gauge, or derive for
+// the counters of INFO (which reset to zero when the server restarts, which
+// derive ignores), with the section and name as the type instance, e.g.
+// cache01/redis-localhost_6379/derive-stats_keyspace_hits. A metric's type
+// can be overridden with -value-type, and a section's with -route-type.
+//
+// For dashboards built on other collectors' identifiers (e.g. collectd's own
+// redis plugin), a metric can be reported as any type and type instance with
+// -map, e.g. clients/connected_clients=current_connections-clients. This
+// overrides both the type and the type instance, with or without -typed.
+//
+// Each part of the identifier but the host is sanitized, since metric keys
+// can contain characters (dots, slashes, dashes) which collectd or its write
+// plugins treat as separators.
+type collectdOutput struct {
+	w         io.Writer
+	host      string
+	plugin    string
+	plugins   map[string]string
+	types     map[string]string
+	typed     bool
+	overrides map[string]string
+	maps      map[string]collectdMap
+	interval  time.Duration
+	replace   string
+	lowercase bool
+	multi     bool
+
+	// Reused every interval, to avoid reallocating it.
+	buf []byte
+
+	// The start of each metric's PUTVAL line, which is the same every
+	// interval, so is only sanitized and formatted once.
+	ids map[collectdID]string
+}
+
+// The sections which are notifications, rather than values, and the severity
+// of each.
+var notifSeverities = map[string]string{
+	"metadata": "okay",
+	"big_key":  "warning",
+}
+
+// A collectdID is everything which a PUTVAL line's identifier is made from.
+type collectdID struct {
+	instance, section, typ, name string
+	qualify                      bool
+}
+
+// A collectdMap is the type and type instance which a metric is mapped to.
+type collectdMap struct {
+	typ, instance string
+}
+
+func newCollectdOutput(interval time.Duration) (*collectdOutput, error) {
+	host, err := collectdHost()
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPluginName(*collectdPlugin)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range routePlugins {
+		err = checkPluginName(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, types := range []map[string]string{routeTypes, valueTypes} {
+		for _, t := range types {
+			if t == "" || strings.ContainsAny(t, "/- ") {
+				return nil, fmt.Errorf("invalid type name: %q", t)
+			}
+		}
+	}
+
+	maps := make(map[string]collectdMap, len(metricMaps))
+	for k, v := range metricMaps {
+		cm, err := parseCollectdMap(v)
+		if err != nil {
+			return nil, fmt.Errorf("-map %s: %s", k, err)
+		}
+		maps[k] = cm
+	}
+
+	if !identifierSafe(*sanitizeChar) {
+		return nil, fmt.Errorf("invalid sanitize char: %q", *sanitizeChar)
+	}
+
+	return &collectdOutput{
+		w:         stdout,
+		host:      host,
+		plugin:    *collectdPlugin,
+		plugins:   routePlugins,
+		types:     routeTypes,
+		typed:     *typedValues,
+		overrides: valueTypes,
+		maps:      maps,
+		interval:  interval,
+		replace:   *sanitizeChar,
+		lowercase: *sanitizeLowercase,
+		multi:     *multiValue,
+	}, nil
+}
+
+// collectdHost returns the host name to report metrics as. Unlike the other
+// parts of the identifier, it isn't sanitized, since it's expected to match
+// what collectd (and everything else reporting on the machine) calls it, so
+// can only be rejected if it's unusable.
+func collectdHost() (string, error) {
+	host := *collectdHostname
+	if host == "" {
+		host = os.Getenv("COLLECTD_HOSTNAME")
+	}
+
+	if host == "" {
+		var err error
+		host, err = os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("error getting hostname (set -hostname instead): %s", err)
+		}
+	}
+
+	if host == "" || strings.ContainsAny(host, "/\" \t") {
+		return "", fmt.Errorf("invalid host name: %q", host)
+	}
+
+	return host, nil
+}
+
+// parseCollectdMap parses the type-instance of a -map. The instance is
+// everything after the first dash, since a type can't contain one.
+func parseCollectdMap(s string) (collectdMap, error) {
+	var cm collectdMap
+	cm.typ = s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		cm.typ, cm.instance = s[:i], s[i+1:]
+	}
+
+	if cm.typ == "" || strings.ContainsAny(cm.typ, "/ ") {
+		return cm, fmt.Errorf("invalid type name: %q", cm.typ)
+	}
+
+	if strings.ContainsAny(cm.instance, "/ ") {
+		return cm, fmt.Errorf("invalid type instance: %q", cm.instance)
+	}
+
+	return cm, nil
+}
+
+// checkPluginName returns an error if name can't be used as a collectd plugin
+// name. Dashes separate the plugin from the plugin instance in identifiers, so
+// can't be part of the name itself.
+func checkPluginName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/- ") {
+		return fmt.Errorf("invalid plugin name: %q", name)
+	}
+
+	return nil
+}
+
+func (o *collectdOutput) Write(t time.Time, ms Metrics) error {
+	o.buf = o.buf[:0]
+
+	var vss []*valueSet
+	if o.multi {
+		vss, ms = groupValues(ms)
+	}
+
+	var notifs Metrics
+	for _, m := range ms {
+		if _, ok := notifSeverities[m.Section]; ok {
+			notifs = append(notifs, m)
+			continue
+		}
+
+		f, err := m.Float()
+		if err != nil {
+			continue
+		}
+
+		if cm, ok := o.mapFor(m); ok {
+			o.putval(t, o.id(m, cm.typ, cm.instance, false), f)
+			continue
+		}
+
+		o.putval(t, o.id(m, o.typeOf(m), m.Name(), o.typed), f)
+	}
+
+	for _, vs := range vss {
+		o.putval(t, o.id(vs.metrics[0], vs.group.typ, vs.instance, false), vs.values...)
+	}
+
+	// Each run of metrics with the same instance, section and prefix is one
+	// notification (e.g. an instance's metadata).
+	for len(notifs) > 0 {
+		n := 1
+		for n < len(notifs) && sameNotif(notifs[n], notifs[0]) {
+			n++
+		}
+
+		o.putnotif(t, notifs[:n])
+		notifs = notifs[n:]
+	}
+
+	// Write the whole interval at once, rather than a syscall per line.
+	_, err := o.w.Write(o.buf)
+	return err
+}
+
+// pluginFor returns the plugin and plugin instance which m should be reported
+// under.
+func (o *collectdOutput) pluginFor(m *Metric) string {
+	plugin, ok := o.plugins[m.Section]
+	if !ok {
+		plugin = o.plugin
+	}
+
+	return fmt.Sprintf("%s-%s", o.sanitize(plugin), o.sanitize(m.Instance))
+}
+
+// mapFor returns the type and type instance which m is mapped to by -map, if
+// it is.
+func (o *collectdOutput) mapFor(m *Metric) (collectdMap, bool) {
+	if len(o.maps) == 0 {
+		return collectdMap{}, false
+	}
+
+	if cm, ok := o.maps[m.Section+"/"+m.Name()]; ok {
+		return cm, true
+	}
+
+	cm, ok := o.maps[m.Key]
+	return cm, ok
+}
+
+// typeOf returns the type which m should be reported as.
+func (o *collectdOutput) typeOf(m *Metric) string {
+	if !o.typed {
+		if typ, ok := o.types[m.Section]; ok {
+			return typ
+		}
+
+		return m.Section
+	}
+
+	if len(o.overrides) > 0 {
+		if typ, ok := o.overrides[m.Section+"/"+m.Name()]; ok {
+			return typ
+		}
+
+		if typ, ok := o.overrides[m.Key]; ok {
+			return typ
+		}
+	}
+
+	if typ, ok := o.types[m.Section]; ok {
+		return typ
+	}
+
+	if isCounter(m) {
+		return "derive"
+	}
+
+	return "gauge"
+}
+
+// id returns the start of the PUTVAL line for m, reported as the given type
+// and type instance (prefixed with the section, if qualify is set), up to the
+// values. If the type instance is empty, the identifier ends at the type.
+func (o *collectdOutput) id(m *Metric, typ, instance string, qualify bool) string {
+	k := collectdID{m.Instance, m.Section, typ, instance, qualify}
+	if s, ok := o.ids[k]; ok {
+		return s
+	}
+
+	if o.ids == nil || len(o.ids) >= maxInterned {
+		o.ids = make(map[collectdID]string)
+	}
+
+	b := append([]byte("PUTVAL "), o.host...)
+	b = append(b, '/')
+	b = append(b, o.pluginFor(m)...)
+	b = append(b, '/')
+	b = append(b, o.sanitize(typ)...)
+	if instance != "" {
+		b = append(b, '-')
+		if qualify {
+			b = append(b, o.sanitize(m.Section)...)
+			b = append(b, '_')
+		}
+		b = append(b, o.sanitize(instance)...)
+	}
+	b = append(b, " interval="...)
+	b = strconv.AppendFloat(b, o.interval.Seconds(), 'f', 6, 64)
+	b = append(b, ' ')
+
+	s := string(b)
+	o.ids[k] = s
+	return s
+}
+
+// putval appends a PUTVAL line to the buffer. It's formatted by hand since
+// there can be thousands of these per interval, and fmt is much slower.
+func (o *collectdOutput) putval(t time.Time, id string, values ...float64) {
+	b := append(o.buf, id...)
+	b = strconv.AppendInt(b, t.Unix(), 10)
+
+	for _, f := range values {
+		b = append(b, ':')
+		b = strconv.AppendFloat(b, f, 'f', 6, 64)
+	}
+
+	o.buf = append(b, '\n')
+}
+
+// sameNotif returns true if a and b are part of the same notification.
+func sameNotif(a, b *Metric) bool {
+	return a.Instance == b.Instance && a.Section == b.Section && a.Prefix == b.Prefix
+}
+
+// putnotif appends a PUTNOTIF line to the buffer, of one notification.
+func (o *collectdOutput) putnotif(t time.Time, ms Metrics) {
+	section := ms[0].Section
+	plugin, ok := o.plugins[section]
+	if !ok {
+		plugin = o.plugin
+	}
+
+	b := append(o.buf, "PUTNOTIF host="...)
+	b = append(b, o.host...)
+	b = append(b, " plugin="...)
+	b = append(b, o.sanitize(plugin)...)
+	b = append(b, " plugin_instance="...)
+	b = append(b, o.sanitize(ms[0].Instance)...)
+
+	b = append(b, " type="...)
+	b = append(b, o.sanitize(section)...)
+	b = append(b, " severity="...)
+	b = append(b, notifSeverities[section]...)
+	b = append(b, " time="...)
+	b = strconv.AppendInt(b, t.Unix(), 10)
+	b = append(b, " message="...)
+	b = append(b, notifMessage(ms)...)
+	o.buf = append(b, '\n')
+}
+
+// sanitize returns s with any characters which can't be part of a collectd
+// identifier replaced.
+func (o *collectdOutput) sanitize(s string) string {
+	if o.lowercase {
+		s = strings.ToLower(s)
+	}
+
+	if identifierSafe(s) {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		if identifierSafeRune(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteString(o.replace)
+		}
+	}
+
+	return b.String()
+}
+
+// identifierSafe returns true if s contains only characters which are safe to
+// use in any part of a collectd identifier.
+func identifierSafe(s string) bool {
+	for _, r := range s {
+		if !identifierSafeRune(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func identifierSafeRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	esURL        = flag.String("es-url", "http://localhost:9200", "elasticsearch/opensearch url")
+	esIndex      = flag.String("es-index", "redis", "elasticsearch index prefix; the date is appended to this")
+	esDateFormat = flag.String("es-index-date-format", "2006.01.02", "date suffix of elasticsearch index names, as a go time layout")
+	esUsername   = flag.String("es-username", "", "elasticsearch username")
+	esPassword   = flag.String("es-password", "", "elasticsearch password")
+	esAPIKey     = flag.String("es-api-key", "", "elasticsearch api key (instead of username and password)")
+	esTemplate   = flag.Bool("es-template", true, "install an index template for the metric indices at startup")
+	esRetries    = flag.Int("es-retries", 3, "number of times to retry a failed request to elasticsearch")
+	esSkipVerify = flag.Bool("es-tls-skip-verify", false, "don't verify the elasticsearch server's certificate")
+)
+
+// The mapping installed for the metric indices. Everything but the value is a
+// keyword, so dashboards can filter and split on section and key.
+const esIndexTemplate = `{
+  "index_patterns": [%q],
+  "template": {
+    "mappings": {
+      "dynamic": false,
+      "properties": {
+        "@timestamp": {"type": "date", "format": "epoch_second"},
+        "instance":   {"type": "keyword"},
+        "section":    {"type": "keyword"},
+        "key":        {"type": "keyword"},
+        "value":      {"type": "double"}
+      }
+    }
+  }
+}`
+
+// elasticsearchOutput bulk-indexes one document per metric (per interval)
+// into a dated index, e.g. redis-2016.01.02. The same API is supported by
+// OpenSearch.
+type elasticsearchOutput struct {
+	client *http.Client
+	header http.Header
+}
+
+type esDocument struct {
+	Timestamp int64   `json:"@timestamp"`
+	Instance  string  `json:"instance"`
+	Section   string  `json:"section"`
+	Key       string  `json:"key"`
+	Value     float64 `json:"value"`
+}
+
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func newElasticsearchOutput(interval time.Duration) (*elasticsearchOutput, error) {
+	h := http.Header{}
+	if *esAPIKey != "" {
+		h.Set("Authorization", "ApiKey "+*esAPIKey)
+	} else if *esUsername != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(*esUsername + ":" + *esPassword))
+		h.Set("Authorization", "Basic "+creds)
+	}
+
+	o := &elasticsearchOutput{
+		client: &http.Client{
+			Timeout: interval,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: *esSkipVerify},
+			},
+		},
+		header: h,
+	}
+
+	if *esTemplate {
+		body := fmt.Sprintf(esIndexTemplate, *esIndex+"-*")
+		_, err := o.do("PUT", "/_index_template/"+*esIndex, "application/json", []byte(body))
+		if err != nil {
+			return nil, fmt.Errorf("error installing index template: %s", err)
+		}
+	}
+
+	return o, nil
+}
+
+func (o *elasticsearchOutput) Write(t time.Time, ms Metrics) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	action := map[string]map[string]string{
+		"index": {"_index": fmt.Sprintf("%s-%s", *esIndex, t.UTC().Format(*esDateFormat))},
+	}
+
+	n := 0
+	for _, m := range ms {
+		f, err := m.Float()
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+
+		// The json encoder terminates each value with a newline, which is
+		// exactly what the bulk api's ndjson format needs.
+		err = enc.Encode(action)
+		if err != nil {
+			return err
+		}
+
+		err = enc.Encode(&esDocument{
+			Timestamp: t.Unix(),
+			Instance:  m.Instance,
+			Section:   m.Section,
+			Key:       m.Name(),
+			Value:     f,
+		})
+		if err != nil {
+			return err
+		}
+
+		n += 1
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	b, err := o.do("POST", "/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// The bulk api returns 200 even if some (or all) of the documents were
+	// rejected, so look inside for the first error.
+	var res esBulkResponse
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		return err
+	}
+
+	if res.Errors {
+		for _, item := range res.Items {
+			for _, r := range item {
+				if r.Status >= 300 {
+					return fmt.Errorf("elasticsearch rejected document: %s: %s", r.Error.Type, r.Error.Reason)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *elasticsearchOutput) do(method, path, contentType string, body []byte) ([]byte, error) {
+	h := http.Header{"Content-Type": {contentType}}
+	for k, vs := range o.header {
+		h[k] = vs
+	}
+
+	url := strings.TrimSuffix(*esURL, "/") + path
+	return httpDo(o.client, method, url, h, body, *esRetries)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	mqttBroker        = flag.String("mqtt-broker", "tcp://localhost:1883", "mqtt broker url (use ssl:// for tls)")
+	mqttTopic         = flag.String("mqtt-topic", "redis/{hostname}/{section}/{key}", "mqtt topic template")
+	mqttQoS           = flag.Int("mqtt-qos", 0, "mqtt quality of service (0, 1 or 2)")
+	mqttClientID      = flag.String("mqtt-client-id", "", "mqtt client id (default: collectd-more-redis-<hostname>)")
+	mqttUsername      = flag.String("mqtt-username", "", "mqtt username")
+	mqttPassword      = flag.String("mqtt-password", "", "mqtt password")
+	mqttTLSCA         = flag.String("mqtt-tls-ca", "", "path to CA certificate for verifying the mqtt broker")
+	mqttTLSCert       = flag.String("mqtt-tls-cert", "", "path to client certificate for mqtt")
+	mqttTLSKey        = flag.String("mqtt-tls-key", "", "path to client key for mqtt")
+	mqttTLSSkipVerify = flag.Bool("mqtt-tls-skip-verify", false, "don't verify the mqtt broker's certificate")
+)
+
+// mqttOutput publishes each metric as a separate message, to a topic built
+// from the -mqtt-topic template. The template may contain the placeholders
+// {hostname}, {instance}, {section} and {key}.
+type mqttOutput struct {
+	client   mqtt.Client
+	qos      byte
+	topic    string
+	hostname string
+	timeout  time.Duration
+}
+
+func newMQTTOutput(interval time.Duration) (*mqttOutput, error) {
+	if *mqttQoS < 0 || *mqttQoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos: %d", *mqttQoS)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	id := *mqttClientID
+	if id == "" {
+		id = fmt.Sprintf("collectd-more-redis-%s", hostname)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(*mqttBroker).
+		SetClientID(id).
+		SetUsername(*mqttUsername).
+		SetPassword(*mqttPassword).
+		SetAutoReconnect(true)
+
+	if *mqttTLSCA != "" || *mqttTLSCert != "" || *mqttTLSKey != "" || *mqttTLSSkipVerify {
+		tc, err := getTLSConfig(*mqttTLSCA, *mqttTLSCert, *mqttTLSKey, *mqttTLSSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.SetTLSConfig(tc)
+	}
+
+	c := mqtt.NewClient(opts)
+	tok := c.Connect()
+	if !tok.WaitTimeout(interval) {
+		return nil, fmt.Errorf("timed out connecting to mqtt broker: %s", *mqttBroker)
+	}
+	if err := tok.Error(); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("# connected to MQTT broker: %s\n", *mqttBroker)
+	return &mqttOutput{
+		client:   c,
+		qos:      byte(*mqttQoS),
+		topic:    *mqttTopic,
+		hostname: hostname,
+		timeout:  interval,
+	}, nil
+}
+
+func (o *mqttOutput) Write(t time.Time, ms Metrics) error {
+	toks := make([]mqtt.Token, 0, len(ms))
+
+	for _, m := range ms {
+		f, err := m.Float()
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+
+		topic := strings.NewReplacer(
+			"{hostname}", o.hostname,
+			"{instance}", m.Instance,
+			"{section}", m.Section,
+			"{key}", m.Name(),
+		).Replace(o.topic)
+
+		payload := fmt.Sprintf(`{"time":%d,"value":%g}`, t.Unix(), f)
+		toks = append(toks, o.client.Publish(topic, o.qos, false, payload))
+	}
+
+	// With QoS 0 the tokens complete as soon as the message is written, so
+	// this only really waits for acknowledgement at the higher levels.
+	deadline := time.Now().Add(o.timeout)
+	for _, tok := range toks {
+		if !tok.WaitTimeout(time.Until(deadline)) {
+			return fmt.Errorf("timed out publishing to mqtt broker")
+		}
+		if err := tok.Error(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	newrelicURL       = flag.String("newrelic-url", "https://metric-api.newrelic.com/metric/v1", "new relic metric api url (use metric-api.eu.newrelic.com for eu accounts)")
+	newrelicAPIKey    = flag.String("newrelic-api-key", "", "new relic license or insert key")
+	newrelicBatchSize = flag.Int("newrelic-batch-size", 2000, "number of metrics to send per request to new relic")
+	newrelicRetries   = flag.Int("newrelic-retries", 3, "number of times to retry a failed request to new relic")
+)
+
+// newrelicOutput sends metrics to the New Relic dimensional Metric API.
+//
+// Counters are sent as the count type, which New Relic expects to contain
+// the change over an interval rather than the running total, so nothing is
+// sent for them until the second interval. The calls and usec counters of
+// each command are also combined into a summary (of the time spent in that
+// command), since that's how they're most naturally queried.
+type newrelicOutput struct {
+	client   *http.Client
+	header   http.Header
+	interval time.Duration
+	attrs    map[string]interface{}
+	prev     map[string]float64
+}
+
+type nrPayload struct {
+	Common  nrCommon   `json:"common"`
+	Metrics []nrMetric `json:"metrics"`
+}
+
+type nrCommon struct {
+	Timestamp  int64                  `json:"timestamp"`
+	IntervalMs int64                  `json:"interval.ms"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type nrMetric struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Value      interface{}            `json:"value"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// nrSummary is the value of a summary metric. Redis doesn't tell us the min
+// or max of anything, so those are always null.
+type nrSummary struct {
+	Count float64  `json:"count"`
+	Sum   float64  `json:"sum"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+}
+
+func newNewRelicOutput(interval time.Duration) (*newrelicOutput, error) {
+	if *newrelicAPIKey == "" {
+		return nil, fmt.Errorf("-newrelic-api-key is required for the newrelic output")
+	}
+
+	if *newrelicBatchSize < 1 {
+		return nil, fmt.Errorf("invalid newrelic batch size: %d", *newrelicBatchSize)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &newrelicOutput{
+		client: &http.Client{Timeout: interval},
+		header: http.Header{
+			"Api-Key":          {*newrelicAPIKey},
+			"Content-Type":     {"application/json"},
+			"Content-Encoding": {"gzip"},
+		},
+		interval: interval,
+		attrs: map[string]interface{}{
+			"host.name": hostname,
+		},
+		prev: map[string]float64{},
+	}, nil
+}
+
+func (o *newrelicOutput) Write(t time.Time, ms Metrics) error {
+	nms := make([]nrMetric, 0, len(ms))
+
+	// The deltas of each command's calls and usec (per instance), to build
+	// summaries from once all of the metrics have been seen.
+	type command struct{ instance, name string }
+	calls := map[command]float64{}
+	usecs := map[command]float64{}
+
+	for _, m := range ms {
+		f, err := m.Float()
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+
+		name := metricPath("redis", m)
+		attrs := map[string]interface{}{"instance": m.Instance}
+
+		if !isCounter(m) {
+			nms = append(nms, nrMetric{Name: name, Type: "gauge", Value: f, Attributes: attrs})
+			continue
+		}
+
+		k := m.Instance + "/" + name
+		prev, ok := o.prev[k]
+		o.prev[k] = f
+
+		// Skip the first sample, and any sample after the counter has gone
+		// backwards (i.e. the server was restarted).
+		if !ok || f < prev {
+			continue
+		}
+
+		d := f - prev
+		if m.Section == "commandstats" && (m.Key == "calls" || m.Key == "usec") {
+			cmd := command{m.Instance, m.Prefix}
+			if m.Key == "calls" {
+				calls[cmd] = d
+			} else {
+				usecs[cmd] = d
+			}
+			continue
+		}
+
+		nms = append(nms, nrMetric{Name: name, Type: "count", Value: d, Attributes: attrs})
+	}
+
+	for cmd, n := range calls {
+		usec, ok := usecs[cmd]
+		if !ok {
+			continue
+		}
+
+		nms = append(nms, nrMetric{
+			Name:       fmt.Sprintf("redis.commandstats.%s.duration", cmd.name),
+			Type:       "summary",
+			Value:      nrSummary{Count: n, Sum: usec / 1e6},
+			Attributes: map[string]interface{}{"instance": cmd.instance},
+		})
+	}
+
+	for i := 0; i < len(nms); i += *newrelicBatchSize {
+		j := i + *newrelicBatchSize
+		if j > len(nms) {
+			j = len(nms)
+		}
+
+		err := o.send(t, nms[i:j])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *newrelicOutput) send(t time.Time, nms []nrMetric) error {
+	payload := []nrPayload{{
+		Common: nrCommon{
+			Timestamp:  t.UnixNano() / int64(time.Millisecond),
+			IntervalMs: int64(o.interval / time.Millisecond),
+			Attributes: o.attrs,
+		},
+		Metrics: nms,
+	}}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+
+	err := json.NewEncoder(zw).Encode(payload)
+	if err != nil {
+		return err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = httpDo(o.client, "POST", *newrelicURL, o.header, buf.Bytes(), *newrelicRetries)
+	return err
+}
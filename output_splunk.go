@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	splunkURL        = flag.String("splunk-url", "https://localhost:8088/services/collector", "splunk http event collector url")
+	splunkToken      = flag.String("splunk-token", "", "splunk http event collector token")
+	splunkIndex      = flag.String("splunk-index", "", "splunk metrics index (default: the token's default index)")
+	splunkSourcetype = flag.String("splunk-sourcetype", "redis", "splunk sourcetype")
+	splunkBatchSize  = flag.Int("splunk-batch-size", 500, "number of metrics to send per request to splunk")
+	splunkRetries    = flag.Int("splunk-retries", 3, "number of times to retry a failed request to splunk")
+	splunkSkipVerify = flag.Bool("splunk-tls-skip-verify", false, "don't verify the splunk server's certificate")
+)
+
+// splunkOutput sends metrics to a Splunk HTTP Event Collector, as one metric
+// event per value. Events are batched, several per request.
+type splunkOutput struct {
+	client   *http.Client
+	header   http.Header
+	hostname string
+}
+
+type splunkEvent struct {
+	Time       int64                  `json:"time"`
+	Event      string                 `json:"event"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	Sourcetype string                 `json:"sourcetype,omitempty"`
+	Index      string                 `json:"index,omitempty"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+func newSplunkOutput(interval time.Duration) (*splunkOutput, error) {
+	if *splunkToken == "" {
+		return nil, fmt.Errorf("-splunk-token is required for the splunk output")
+	}
+
+	if *splunkBatchSize < 1 {
+		return nil, fmt.Errorf("invalid splunk batch size: %d", *splunkBatchSize)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &splunkOutput{
+		client: &http.Client{
+			Timeout: interval,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: *splunkSkipVerify},
+			},
+		},
+		header: http.Header{
+			"Authorization": {"Splunk " + *splunkToken},
+			"Content-Type":  {"application/json"},
+		},
+		hostname: hostname,
+	}, nil
+}
+
+func (o *splunkOutput) Write(t time.Time, ms Metrics) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	n := 0
+
+	for _, m := range ms {
+		f, err := m.Float()
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+
+		err = enc.Encode(&splunkEvent{
+			Time:       t.Unix(),
+			Event:      "metric",
+			Host:       o.hostname,
+			Source:     m.Instance,
+			Sourcetype: *splunkSourcetype,
+			Index:      *splunkIndex,
+			Fields: map[string]interface{}{
+				"metric_name": metricPath("redis", m),
+				"_value":      f,
+				"instance":    m.Instance,
+				"section":     m.Section,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		n += 1
+		if n == *splunkBatchSize {
+			_, err = httpDo(o.client, "POST", *splunkURL, o.header, buf.Bytes(), *splunkRetries)
+			if err != nil {
+				return err
+			}
+
+			buf.Reset()
+			n = 0
+		}
+	}
+
+	if n > 0 {
+		_, err := httpDo(o.client, "POST", *splunkURL, o.header, buf.Bytes(), *splunkRetries)
+		return err
+	}
+
+	return nil
+}
+
+// metricPath returns the dotted name of a metric, like redis.memory.used_memory
+// or redis.commandstats.cmdstat_get.calls, for backends with flat namespaces.
+func metricPath(root string, m *Metric) string {
+	return strings.Join([]string{root, m.Section, strings.Replace(m.Name(), "/", ".", -1)}, ".")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	wavefrontProxy   = flag.String("wavefront-proxy", "", "wavefront proxy address, e.g. localhost:2878")
+	wavefrontURL     = flag.String("wavefront-url", "", "wavefront url for direct ingestion, e.g. https://example.wavefront.com")
+	wavefrontToken   = flag.String("wavefront-token", "", "wavefront api token (for direct ingestion)")
+	wavefrontSource  = flag.String("wavefront-source", "", "wavefront source (default: this hostname)")
+	wavefrontTags    = flag.String("wavefront-tags", "", "extra wavefront point tags, as comma-separated key=value pairs")
+	wavefrontRetries = flag.Int("wavefront-retries", 3, "number of times to retry a failed request to wavefront")
+)
+
+// wavefrontOutput sends metrics in the Wavefront data format, either to a
+// proxy (as plain lines over TCP) or directly to a Wavefront/Aria instance.
+// Each point is tagged with the Redis instance and section, plus any tags
+// given with -wavefront-tags.
+type wavefrontOutput struct {
+	client  *http.Client
+	conn    net.Conn
+	timeout time.Duration
+	source  string
+	tags    string
+}
+
+func newWavefrontOutput(interval time.Duration) (*wavefrontOutput, error) {
+	if (*wavefrontProxy == "") == (*wavefrontURL == "") {
+		return nil, fmt.Errorf("exactly one of -wavefront-proxy and -wavefront-url is required for the wavefront output")
+	}
+
+	source := *wavefrontSource
+	if source == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		source = h
+	}
+
+	tags := map[string]string{}
+
+	if *wavefrontTags != "" {
+		for _, pair := range strings.Split(*wavefrontTags, ",") {
+			tupl := strings.SplitN(pair, "=", 2)
+			if len(tupl) != 2 {
+				return nil, fmt.Errorf("invalid wavefront tag: %s", pair)
+			}
+
+			tags[strings.TrimSpace(tupl[0])] = strings.TrimSpace(tupl[1])
+		}
+	}
+
+	o := &wavefrontOutput{
+		client:  &http.Client{Timeout: interval},
+		timeout: interval,
+		source:  source,
+		tags:    wavefrontTagString(tags),
+	}
+
+	if *wavefrontProxy != "" {
+		err := o.dial()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+func (o *wavefrontOutput) dial() error {
+	conn, err := net.DialTimeout("tcp", *wavefrontProxy, o.timeout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("# connected to Wavefront proxy: %s\n", *wavefrontProxy)
+	o.conn = conn
+	return nil
+}
+
+func (o *wavefrontOutput) Write(t time.Time, ms Metrics) error {
+	var buf bytes.Buffer
+
+	for _, m := range ms {
+		f, err := m.Float()
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "%s %s %d source=%s instance=%s section=%s%s\n",
+			wavefrontQuote(metricPath("redis", m)),
+			strconv.FormatFloat(f, 'f', -1, 64),
+			t.Unix(),
+			wavefrontQuote(o.source),
+			wavefrontQuote(m.Instance),
+			wavefrontQuote(m.Section),
+			o.tags)
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	if *wavefrontURL != "" {
+		h := http.Header{"Content-Type": {"text/plain"}}
+		if *wavefrontToken != "" {
+			h.Set("Authorization", "Bearer "+*wavefrontToken)
+		}
+
+		url := strings.TrimSuffix(*wavefrontURL, "/") + "/report?f=wavefront"
+		_, err := httpDo(o.client, "POST", url, h, buf.Bytes(), *wavefrontRetries)
+		return err
+	}
+
+	// If the proxy went away during the last interval, redial it now.
+	if o.conn == nil {
+		err := o.dial()
+		if err != nil {
+			return err
+		}
+	}
+
+	o.conn.SetWriteDeadline(time.Now().Add(o.timeout))
+	_, err := o.conn.Write(buf.Bytes())
+	if err != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+
+	return err
+}
+
+// wavefrontTagString formats point tags (with a leading space), sorted so
+// that every line is tagged identically.
+func wavefrontTagString(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := ""
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s=%s", wavefrontQuote(k), wavefrontQuote(tags[k]))
+	}
+
+	return s
+}
+
+func wavefrontQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
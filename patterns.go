@@ -0,0 +1,356 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	keyPatterns    = mapFlag{}
+	patternScan    = flag.Int("pattern-scan", 1000, "how many keys to look at each run, for -pattern")
+	patternSamples = flag.Int("pattern-samples", 20, "how many of the keys which match each -pattern to ask the MEMORY USAGE of each run")
+)
+
+func init() {
+	flag.Var(keyPatterns, "pattern", "estimate how many keys match a pattern (e.g. session:*), and how much memory they use, from a sample, as name=pattern (repeatable)")
+}
+
+// INFO says how much memory the server uses, but not what it's used by. Since
+// keys are usually named by what they're for (e.g. session:1234), patterns of
+// key names can say. The keyspace is sampled with SCAN, -pattern-scan keys per
+// run (carrying on from where the last run left off), and the fraction of
+// them which match each pattern, times the number of keys in the database,
+// estimates how many keys it matches. Up to -pattern-samples of those are
+// asked their MEMORY USAGE, the average of which estimates how much memory
+// they use in all. The estimates are from every key sampled since the scan
+// last started from the beginning of the keyspace, so they steady as it
+// goes.
+//
+// The patterns are globs, like SCAN's MATCH, but matched by the collector, so
+// that one scan serves them all. They're reported in the patterns section,
+// with the name as the prefix, every minute by default (see -schedule).
+//
+// Each master of a discovered cluster only has its own share of the keys, and
+// its replicas have copies of them, so only the masters sample. Their latest
+// counts are added up across the cluster, and the estimates from them are
+// reported under the cluster (see shards.go), by the first of its masters by
+// name.
+
+// A keyPattern is a named pattern of key names.
+type keyPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// The parsed -pattern patterns, by name.
+var parsedPatterns []keyPattern
+
+// checkPatterns parses the -pattern patterns, or returns an error if any of
+// them are invalid.
+func checkPatterns(patterns map[string]string) error {
+	if len(patterns) > 0 && (*patternScan < 1 || *patternSamples < 0) {
+		return fmt.Errorf("-pattern-scan must be at least 1, and -pattern-samples at least 0")
+	}
+
+	parsed := make([]keyPattern, 0, len(patterns))
+	for name, glob := range patterns {
+		re, err := globRegexp(glob)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		parsed = append(parsed, keyPattern{name, re})
+	}
+
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].name < parsed[j].name
+	})
+
+	parsedPatterns = parsed
+	return nil
+}
+
+// globRegexp returns a regexp which matches the same strings as the glob, as
+// redis matches them: * is any string, ? any character, [...] any of a set
+// of characters (or with ^, any not in it), and \ escapes the next one.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`^`)
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+
+		case '?':
+			b.WriteString(`.`)
+
+		case '[':
+			j := strings.IndexByte(glob[i+1:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("unclosed [ in %q", glob)
+			}
+
+			set := glob[i+1 : i+1+j]
+			b.WriteString(`[`)
+			if strings.HasPrefix(set, "^") {
+				b.WriteString(`^`)
+				set = set[1:]
+			}
+
+			// Ranges like a-z are written the same, since QuoteMeta
+			// leaves dashes alone.
+			for k := 0; k < len(set); k++ {
+				b.WriteString(regexp.QuoteMeta(string(set[k])))
+			}
+
+			b.WriteString(`]`)
+			i += j + 1
+
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString(`$`)
+	return regexp.Compile(b.String())
+}
+
+// The keys sampled which matched a pattern, and how many of them were asked
+// their memory usage, and how much it was.
+type patternSample struct {
+	matched int64
+	sized   int64
+	bytes   int64
+}
+
+// A patternSampler samples the keyspace of a target for the patterns.
+type patternSampler struct {
+	target   *Target
+	scan     int
+	cursor   string
+	examined int64
+	samples  map[string]*patternSample
+}
+
+// The patternCounts of a sample, as of its latest run.
+type patternCounts struct {
+	examined int64
+	dbsize   int64
+	samples  map[string]patternSample
+}
+
+// The latest counts of the masters of each discovered cluster, by cluster
+// and node.
+var (
+	clusterPatternsMu sync.Mutex
+	clusterPatterns   = map[string]map[string]*patternCounts{}
+)
+
+var patternsCollector = &collector{
+	name:     "patterns",
+	commands: []string{"DBSIZE", "SCAN", "MEMORY USAGE"},
+	every:    time.Minute,
+	keyed:    true,
+	newCollect: func(tg *Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+		s := &patternSampler{target: tg, scan: tg.scanBudget(*patternScan), cursor: "0"}
+		s.reset()
+
+		// This is called with t.mu held, so the node's counts can be
+		// dropped along with it.
+		if tg.seed != "" {
+			tg.closers = append(tg.closers, s.forget)
+		}
+
+		return s.collect
+	},
+}
+
+// reset starts the sample again, for a new scan of the keyspace.
+func (s *patternSampler) reset() {
+	s.examined = 0
+	s.samples = make(map[string]*patternSample, len(parsedPatterns))
+	for _, p := range parsedPatterns {
+		s.samples[p.name] = &patternSample{}
+	}
+}
+
+func (s *patternSampler) collect(conn redis.Conn, t time.Time) (Metrics, error) {
+	clustered := s.target.seed != ""
+	if clustered {
+		s.target.mu.Lock()
+		role := s.target.role
+		s.target.mu.Unlock()
+
+		if role == "cluster_replica" {
+			s.forget()
+			return nil, nil
+		}
+	}
+
+	dbsize, err := redis.Int64(conn.Do("DBSIZE"))
+	if err != nil {
+		return nil, err
+	}
+
+	sized := map[string]int{}
+	done := false
+	for n := 0; n < s.scan && !done; {
+		reply, err := redis.Values(conn.Do("SCAN", s.cursor, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []string
+		_, err = redis.Scan(reply, &s.cursor, &keys)
+		if err != nil {
+			return nil, err
+		}
+
+		n += len(keys)
+		s.examined += int64(len(keys))
+		done = s.cursor == "0"
+
+		for _, k := range keys {
+			for _, p := range parsedPatterns {
+				if !p.re.MatchString(k) {
+					continue
+				}
+
+				ps := s.samples[p.name]
+				ps.matched++
+				if sized[p.name] >= *patternSamples {
+					continue
+				}
+
+				// The key might have gone since it was scanned, or its
+				// slot moved to another node.
+				bytes, err := redis.Int64(conn.Do("MEMORY", "USAGE", k))
+				if err == redis.ErrNil || err == errNotOwned {
+					continue
+				}
+
+				if err != nil {
+					return nil, err
+				}
+
+				sized[p.name]++
+				ps.sized++
+				ps.bytes += bytes
+			}
+		}
+	}
+
+	c := s.counts(dbsize)
+	if clustered {
+		c = s.share(c)
+	}
+
+	// Once the whole keyspace has been scanned, the next scan starts a new
+	// sample, so that the estimates follow the keyspace as it changes.
+	if done {
+		s.reset()
+	}
+
+	if c == nil {
+		return nil, nil
+	}
+
+	return c.metrics(), nil
+}
+
+// counts returns a copy of the sample's counts.
+func (s *patternSampler) counts(dbsize int64) *patternCounts {
+	c := &patternCounts{examined: s.examined, dbsize: dbsize, samples: make(map[string]patternSample, len(s.samples))}
+	for name, ps := range s.samples {
+		c.samples[name] = *ps
+	}
+
+	return c
+}
+
+// share records the counts of the cluster node, and if it's the one which
+// reports the cluster's estimates, returns the sum of its masters' counts.
+// Otherwise it returns nil.
+func (s *patternSampler) share(c *patternCounts) *patternCounts {
+	clusterPatternsMu.Lock()
+	defer clusterPatternsMu.Unlock()
+
+	nodes, ok := clusterPatterns[s.target.Cluster]
+	if !ok {
+		nodes = map[string]*patternCounts{}
+		clusterPatterns[s.target.Cluster] = nodes
+	}
+
+	nodes[s.target.Name] = c
+	for name := range nodes {
+		if name < s.target.Name {
+			return nil
+		}
+	}
+
+	sum := &patternCounts{samples: map[string]patternSample{}}
+	for _, nc := range nodes {
+		sum.examined += nc.examined
+		sum.dbsize += nc.dbsize
+		for name, ps := range nc.samples {
+			t := sum.samples[name]
+			t.matched += ps.matched
+			t.sized += ps.sized
+			t.bytes += ps.bytes
+			sum.samples[name] = t
+		}
+	}
+
+	return sum
+}
+
+// forget drops the cluster node's counts, e.g. once it's a replica, or has
+// gone.
+func (s *patternSampler) forget() {
+	clusterPatternsMu.Lock()
+	defer clusterPatternsMu.Unlock()
+
+	delete(clusterPatterns[s.target.Cluster], s.target.Name)
+}
+
+// metrics returns the estimates from the counts.
+func (c *patternCounts) metrics() Metrics {
+	ms := make(Metrics, 0, len(parsedPatterns)*3)
+	add := func(prefix, key string, f float64) {
+		ms = append(ms, &Metric{Section: "patterns", Prefix: prefix, Key: key, Value: strconv.FormatFloat(f, 'f', 0, 64)})
+	}
+
+	if c.examined == 0 {
+		return ms
+	}
+
+	for _, p := range parsedPatterns {
+		ps := c.samples[p.name]
+		keys := float64(ps.matched) / float64(c.examined) * float64(c.dbsize)
+		add(p.name, "estimated_keys", keys)
+
+		if ps.sized > 0 {
+			avg := float64(ps.bytes) / float64(ps.sized)
+			add(p.name, "avg_bytes", avg)
+			add(p.name, "estimated_bytes", keys*avg)
+		}
+	}
+
+	return ms
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	prewarm = flag.Int("prewarm", 16, "how many targets to connect to at once at startup, before the first collection (0 to connect to each when it's first collected)")
+)
+
+// Targets are collected from one after another, so with hundreds of them,
+// connecting to each the first time it's collected (resolving its name,
+// handshaking TLS, authenticating) can take up most of the first interval, or
+// longer if some can't be reached. So they're all connected to at startup,
+// -prewarm at a time, and the first collection finds them ready.
+
+// Prewarm connects to each active target which isn't connected yet, n at a
+// time, and returns once they've all connected or failed to. Targets which
+// can't be reached are retried as usual, after backing off.
+func (ts *Targets) Prewarm(now time.Time, n int) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, n)
+
+	for _, t := range ts.Active() {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(t *Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			t.warm(now)
+		}(t)
+	}
+
+	wg.Wait()
+}
+
+// warm connects to the target, if it isn't connected. If it can't be reached,
+// the error is logged as if it were collected, so it isn't logged again then.
+func (t *Target) warm(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.removed || t.conn != nil || now.Before(t.retryAt) {
+		return
+	}
+
+	conn, err := t.connect()
+	if err != nil {
+		t.retryLater(now)
+		t.failing = true
+		fmt.Printf("error connecting to %s (retrying until it's back):\n", t.Name)
+		fmt.Println(err)
+		return
+	}
+
+	t.conn = conn
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	minPriority      = flag.String("min-priority", "verbose", "only report the sections of at least this priority: verbose (everything), standard, or core")
+	priorityOverride = mapFlag{}
+)
+
+func init() {
+	flag.Var(priorityOverride, "priority", "change the priority of a section, as section=priority, where priority is verbose, standard or core (repeatable)")
+}
+
+// Each section has a priority: core for the ones which say whether a server is
+// healthy (memory, clients, replication, etc), verbose for the ones which can
+// have a series for each of something there can be a lot of (commandstats,
+// errorstats, etc), and standard for the rest. -min-priority drops the
+// sections below it from every output, so one flag says how much detail to
+// report (e.g. core in development, verbose in production), rather than a
+// list of -exclude patterns. The priorities are also the order in which
+// sections are shed from slow outputs (see shed.go).
+
+// The priorities, from lowest to highest.
+const (
+	priorityVerbose = iota
+	priorityStandard
+	priorityCore
+)
+
+var priorityNames = []string{"verbose", "standard", "core"}
+
+// The priority of each section which isn't standard, by default.
+var sectionPriorities = map[string]int{
+	"commandstats": priorityVerbose,
+	"latencystats": priorityVerbose,
+	"errorstats":   priorityVerbose,
+	"client_list":  priorityVerbose,
+	"memory_stats": priorityVerbose,
+
+	"server":      priorityCore,
+	"clients":     priorityCore,
+	"memory":      priorityCore,
+	"persistence": priorityCore,
+	"stats":       priorityCore,
+	"replication": priorityCore,
+	"cluster":     priorityCore,
+	"output":      priorityCore,
+	"metadata":    priorityCore,
+	"big_key":     priorityCore,
+}
+
+// The parsed -min-priority, and -priority overrides.
+var (
+	parsedMinPriority int
+	parsedPriorities  = map[string]int{}
+)
+
+// parsePriority returns the priority with the name.
+func parsePriority(s string) (int, error) {
+	for i, name := range priorityNames {
+		if s == name {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown priority: %q (expected verbose, standard or core)", s)
+}
+
+// checkPriorities parses -min-priority and the -priority overrides, or returns
+// an error if any of them are invalid.
+func checkPriorities(min string, overrides map[string]string) error {
+	p, err := parsePriority(min)
+	if err != nil {
+		return fmt.Errorf("-min-priority: %s", err)
+	}
+
+	parsed := make(map[string]int, len(overrides))
+	for section, s := range overrides {
+		parsed[section], err = parsePriority(s)
+		if err != nil {
+			return fmt.Errorf("%s: %s", section, err)
+		}
+	}
+
+	parsedMinPriority = p
+	parsedPriorities = parsed
+	return nil
+}
+
+// priorityOf returns the priority of the section.
+func priorityOf(section string) int {
+	if p, ok := parsedPriorities[section]; ok {
+		return p
+	}
+
+	if p, ok := sectionPriorities[section]; ok {
+		return p
+	}
+
+	return priorityStandard
+}
+
+// priorityOutput drops the sections below -min-priority.
+type priorityOutput struct {
+	Output
+	min  int
+	kept Metrics
+}
+
+// withPriority wraps out to drop the sections below -min-priority, or returns
+// out as it is if that's verbose.
+func withPriority(out Output) Output {
+	if parsedMinPriority == priorityVerbose {
+		return out
+	}
+
+	return &priorityOutput{Output: out, min: parsedMinPriority}
+}
+
+func (o *priorityOutput) Write(t time.Time, ms Metrics) error {
+	o.kept = o.kept[:0]
+	for _, m := range ms {
+		if priorityOf(m.Section) >= o.min {
+			o.kept = append(o.kept, m)
+		}
+	}
+
+	return o.Output.Write(t, o.kept)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	keyProbes = mapFlag{}
+)
+
+func init() {
+	flag.Var(keyProbes, "probe", "report the length of a key (e.g. the depth of a job queue) as keys/name/length, as name=key[,type=t][,db=n], where t is list, set, zset, hash, string or stream (found with TYPE if not given) (repeatable)")
+}
+
+// Some keys are worth watching in their own right, like lists used as job
+// queues, whose length is how far behind the workers are. A probe reports the
+// length of one key each interval, in the keys section, with the probe's name
+// as the prefix, using the command for the key's type (e.g. LLEN for a list).
+// Keys which don't exist have a length of zero, since redis deletes lists
+// (and sets, etc) when they're emptied.
+
+// The command which returns the length of a key of each type.
+var lengthCommands = map[string]string{
+	"list":   "LLEN",
+	"set":    "SCARD",
+	"zset":   "ZCARD",
+	"hash":   "HLEN",
+	"string": "STRLEN",
+	"stream": "XLEN",
+}
+
+// A keyProbe is a key to report the length of. If typ is empty, it's found
+// with TYPE each time, and if db is -1, the key is in the target's database.
+type keyProbe struct {
+	name string
+	key  string
+	typ  string
+	db   int
+}
+
+// The parsed -probe probes, by name.
+var parsedProbes []keyProbe
+
+// checkProbes parses the -probe probes, or returns an error if any of them
+// are invalid.
+func checkProbes(probes map[string]string) error {
+	parsed := make([]keyProbe, 0, len(probes))
+	for name, s := range probes {
+		fields := strings.Split(s, ",")
+		p := keyProbe{name: name, key: fields[0], db: -1}
+		if p.key == "" {
+			return fmt.Errorf("%s: no key", name)
+		}
+
+		for _, f := range fields[1:] {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%s: expected type=t or db=n, got %q", name, f)
+			}
+
+			switch kv[0] {
+			case "type":
+				if _, ok := lengthCommands[kv[1]]; !ok {
+					return fmt.Errorf("%s: unknown type: %s", name, kv[1])
+				}
+
+				p.typ = kv[1]
+
+			case "db":
+				db, err := strconv.Atoi(kv[1])
+				if err != nil || db < 0 {
+					return fmt.Errorf("%s: bad db: %s", name, kv[1])
+				}
+
+				p.db = db
+
+			default:
+				return fmt.Errorf("%s: expected type=t or db=n, got %q", name, f)
+			}
+		}
+
+		parsed = append(parsed, p)
+	}
+
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].name < parsed[j].name
+	})
+
+	parsedProbes = parsed
+	return nil
+}
+
+// probesCollector returns the collector which reports the length of each
+// probed key. It needs TYPE only if some probes don't say which type their key
+// is, and SELECT only if some are in other databases.
+func probesCollector() *collector {
+	needs := map[string]bool{}
+	for _, p := range parsedProbes {
+		if p.typ == "" {
+			for _, cmd := range lengthCommands {
+				needs[cmd] = true
+			}
+
+			needs["TYPE"] = true
+		} else {
+			needs[lengthCommands[p.typ]] = true
+		}
+
+		if p.db >= 0 {
+			needs["SELECT"] = true
+		}
+	}
+
+	commands := make([]string, 0, len(needs))
+	for cmd := range needs {
+		commands = append(commands, cmd)
+	}
+
+	sort.Strings(commands)
+
+	return &collector{
+		name:     "probes",
+		commands: commands,
+		keyed:    true,
+		newCollect: func(tg *Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+			return func(conn redis.Conn, t time.Time) (Metrics, error) {
+				return probeKeys(conn, intOr(tg.ConnOptions.DB, *redisDB))
+			}
+		},
+	}
+}
+
+// probeKeys returns the length of each probed key. The connection is in the
+// database home, and is left in it.
+func probeKeys(conn redis.Conn, home int) (Metrics, error) {
+	ms := make(Metrics, 0, len(parsedProbes))
+	cur := home
+
+	for _, p := range parsedProbes {
+		db := p.db
+		if db < 0 {
+			db = home
+		}
+
+		err := selectDB(conn, &cur, db)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := keyLength(conn, p.key, p.typ)
+		if err == errNotOwned {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("probing %s: %s", p.name, err)
+		}
+
+		ms = append(ms, &Metric{Section: "keys", Prefix: p.name, Key: "length", Value: strconv.FormatInt(n, 10)})
+	}
+
+	err := selectDB(conn, &cur, home)
+	if err != nil {
+		return nil, err
+	}
+
+	return ms, nil
+}
+
+// selectDB switches the connection to the database, if it isn't already in
+// it, as cur says.
+func selectDB(conn redis.Conn, cur *int, db int) error {
+	if db == *cur {
+		return nil
+	}
+
+	_, err := conn.Do("SELECT", db)
+	if err != nil {
+		return err
+	}
+
+	*cur = db
+	return nil
+}
+
+// keyLength returns the length of the key, which is of the type (or if that's
+// empty, is looked up).
+func keyLength(conn redis.Conn, key, typ string) (int64, error) {
+	if typ == "" {
+		var err error
+		typ, err = redis.String(conn.Do("TYPE", key))
+		if err != nil {
+			return 0, err
+		}
+
+		if typ == "none" {
+			return 0, nil
+		}
+	}
+
+	cmd, ok := lengthCommands[typ]
+	if !ok {
+		return 0, fmt.Errorf("can't get the length of a %s", typ)
+	}
+
+	return redis.Int64(conn.Do(cmd, key))
+}
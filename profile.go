@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	profiles = mapFlag{}
+)
+
+func init() {
+	flag.Var(profiles, "profile", "which collectors to run for targets with a role, as role=name,name, e.g. replica=derived (repeatable)")
+}
+
+// Each target's role is detected from its INFO every interval, and if there's
+// a profile for the role, only the collectors it names run for the target.
+// Targets with roles which have no profile run every collector. The roles are:
+//
+//	master, replica    standalone servers
+//	cluster_master,    nodes of a cluster, which fall back to the master and
+//	cluster_replica    replica profiles if they don't have their own
+//	sentinel           sentinels
+//
+// INFO itself always runs. As well as the background collectors, a profile
+// can name "derived", for the metrics derived from INFO, which are otherwise
+// skipped for targets with that role.
+var roles = map[string][]string{
+	"master":          {"master"},
+	"replica":         {"replica"},
+	"cluster_master":  {"cluster_master", "master"},
+	"cluster_replica": {"cluster_replica", "replica"},
+	"sentinel":        {"sentinel"},
+}
+
+// checkProfiles returns an error if any of the profiles is for a role which
+// doesn't exist, or names a collector which doesn't (or isn't enabled).
+func checkProfiles(p map[string]string) error {
+	names := map[string]bool{"derived": true}
+	for _, c := range enabledCollectors() {
+		names[c.name] = true
+	}
+
+	for role, v := range p {
+		if _, ok := roles[role]; !ok {
+			return fmt.Errorf("no such role: %s", role)
+		}
+
+		for _, name := range profileNames(v) {
+			if !names[name] {
+				return fmt.Errorf("%s: no such collector (or it isn't enabled): %s", role, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func profileNames(v string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// roleOf returns the role of a server, given the role and redis_mode fields of
+// its INFO.
+func roleOf(role, mode string) string {
+	switch {
+	case mode == "sentinel":
+		return "sentinel"
+	case mode == "cluster" && role == "slave":
+		return "cluster_replica"
+	case mode == "cluster":
+		return "cluster_master"
+	case role == "slave":
+		return "replica"
+	default:
+		return "master"
+	}
+}
+
+// profileRuns returns true if the profile for role (if there is one) runs the
+// named collector.
+func profileRuns(role, name string) bool {
+	for _, r := range roles[role] {
+		if v, ok := profiles[r]; ok {
+			for _, n := range profileNames(v) {
+				if n == name {
+					return true
+				}
+			}
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// runs returns true if the named collector should run for the target, given
+// the role it had when INFO was last collected (and its own collectors).
+func (t *Target) runs(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return profileRuns(t.role, name) && t.enabled(name)
+}
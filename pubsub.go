@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	pubsubStats    = flag.Bool("pubsub", false, "report how many pub/sub channels have subscribers, and how many patterns are subscribed to")
+	pubsubChannels = listFlag{}
+)
+
+func init() {
+	flag.Var(&pubsubChannels, "pubsub-channel", "report how many clients are subscribed to a channel, for -pubsub (repeatable)")
+}
+
+// pubsubCollector reports, in the pubsub section, how many channels have at
+// least one subscriber, how many patterns are subscribed to, and how many
+// subscribers each of the -pubsub-channel channels has (with the channel as
+// the prefix). A channel whose subscribers drop to zero is usually a consumer
+// which has died, since publishing to it still works, and the messages are
+// just dropped.
+//
+// PUBSUB CHANNELS lists every active channel, which can be slow when there are
+// a lot of them.
+func pubsubCollector() *collector {
+	commands := []string{"PUBSUB CHANNELS", "PUBSUB NUMPAT"}
+	if len(pubsubChannels) > 0 {
+		commands = append(commands, "PUBSUB NUMSUB")
+	}
+
+	return &collector{
+		name:     "pubsub",
+		commands: commands,
+		collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+			channels, err := redis.Values(conn.Do("PUBSUB", "CHANNELS"))
+			if err != nil {
+				return nil, err
+			}
+
+			patterns, err := redis.Int64(conn.Do("PUBSUB", "NUMPAT"))
+			if err != nil {
+				return nil, err
+			}
+
+			ms := make(Metrics, 0, 2+len(pubsubChannels))
+			add := func(prefix, key string, n int64) {
+				ms = append(ms, &Metric{Section: "pubsub", Prefix: prefix, Key: key, Value: strconv.FormatInt(n, 10)})
+			}
+
+			add("", "channels", int64(len(channels)))
+			add("", "patterns", patterns)
+
+			if len(pubsubChannels) == 0 {
+				return ms, nil
+			}
+
+			args := make([]interface{}, 0, len(pubsubChannels)+1)
+			args = append(args, "NUMSUB")
+			for _, ch := range pubsubChannels {
+				args = append(args, ch)
+			}
+
+			// The reply is each channel followed by its number of
+			// subscribers.
+			subs, err := redis.Values(conn.Do("PUBSUB", args...))
+			if err != nil {
+				return nil, err
+			}
+
+			for i := 0; i+1 < len(subs); i += 2 {
+				ch, err := redis.String(subs[i], nil)
+				if err != nil {
+					return nil, err
+				}
+
+				n, err := redis.Int64(subs[i+1], nil)
+				if err != nil {
+					return nil, err
+				}
+
+				add(ch, "subscribers", n)
+			}
+
+			return ms, nil
+		},
+	}
+}
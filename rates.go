@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+)
+
+var (
+	counterRates = flag.Bool("counter-rates", false, "report the counters of INFO (e.g. total_commands_processed) as their per-second rates since the previous interval, rather than their raw values")
+)
+
+// Most of the counters of INFO only go up, until the server restarts, but
+// most collectd types (and other backends) treat values as gauges, so graph
+// them as ever-rising lines. With -counter-rates, each counter is replaced by
+// its per-second rate since the previous interval, under the same name. The
+// first interval of each counter has no rate, so it's left out, as is the
+// first after it goes backwards (i.e. the server restarted).
+
+// ratesOutput converts the counters to rates.
+type ratesOutput struct {
+	Output
+	prev map[rateKey]rateSample
+	out  Metrics
+}
+
+type rateKey struct {
+	instance, section, name string
+}
+
+// A rateSample is the value of a counter, as of an interval.
+type rateSample struct {
+	t time.Time
+	f float64
+}
+
+// withRates wraps out to convert the counters to rates, if -counter-rates is
+// set.
+func withRates(out Output) Output {
+	if !*counterRates {
+		return out
+	}
+
+	return &ratesOutput{Output: out, prev: map[rateKey]rateSample{}}
+}
+
+func (o *ratesOutput) Write(t time.Time, ms Metrics) error {
+	o.out = o.out[:0]
+	for _, m := range ms {
+		if !counterField(m) {
+			o.out = append(o.out, m)
+			continue
+		}
+
+		f, err := m.Float()
+		if err != nil {
+			o.out = append(o.out, m)
+			continue
+		}
+
+		k := rateKey{m.Instance, m.Section, m.Name()}
+		prev, ok := o.prev[k]
+		if ok && !t.After(prev.t) {
+			continue
+		}
+
+		o.prev[k] = rateSample{t, f}
+		if !ok || f < prev.f {
+			continue
+		}
+
+		r := *m
+		r.Value = strconv.FormatFloat((f-prev.f)/t.Sub(prev.t).Seconds(), 'f', -1, 64)
+		o.out = append(o.out, &r)
+	}
+
+	return o.Output.Write(t, o.out)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+var (
+	reconnectMin = flag.Duration("reconnect-min", time.Second, "how long to wait before first retrying to connect to a server which can't be reached")
+	reconnectMax = flag.Duration("reconnect-max", time.Minute, "the longest to wait between retries to connect to a server which can't be reached")
+)
+
+// If a target can't be reached (e.g. it's restarting), the collector keeps
+// trying to reconnect, waiting twice as long after each failure (up to
+// -reconnect-max), with some jitter so that a fleet of collectors doesn't
+// retry in lockstep. If a connection breaks, it's retried straight away,
+// since it's usually a blip. The error is only logged when the target first
+// goes down, rather than every interval until it's back.
+
+// checkReconnect returns an error if the reconnect flags can't be waited for.
+func checkReconnect() error {
+	if *reconnectMin <= 0 {
+		return fmt.Errorf("-reconnect-min must be positive")
+	}
+
+	if *reconnectMax < *reconnectMin {
+		return fmt.Errorf("-reconnect-max must be at least -reconnect-min")
+	}
+
+	return nil
+}
+
+// errBackingOff is returned when collection from a target is skipped because
+// it's waiting to retry connecting.
+var errBackingOff = errors.New("waiting to reconnect")
+
+// retryLater schedules the next attempt to connect to the target, after a
+// failed one at now. It must be called with t.mu held.
+func (t *Target) retryLater(now time.Time) {
+	switch {
+	case t.backoff == 0:
+		t.backoff = *reconnectMin
+	case t.backoff < *reconnectMax:
+		t.backoff *= 2
+	}
+
+	if t.backoff > *reconnectMax {
+		t.backoff = *reconnectMax
+	}
+
+	wait := t.backoff/2 + time.Duration(rand.Int63n(int64(t.backoff/2)+1))
+	t.retryAt = now.Add(wait)
+}
+
+// failed records the outcome of collecting from the target, and returns true
+// if it failed, having succeeded last time (or never run), so the error is
+// worth logging.
+func (t *Target) failed(err error) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err == nil {
+		t.failing = false
+		return false
+	}
+
+	first := !t.failing
+	t.failing = true
+	return first
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	recordDir = flag.String("record", "", "save every command sent to redis and its reply under this directory")
+	replayDir = flag.String("replay", "", "replay the commands saved with -record from this directory, instead of connecting to redis")
+)
+
+// Recordings are stored one directory per target (named after its escaped
+// name), one file per interval (named after its unix timestamp). Each file is
+// a sequence of commands and replies in the Redis protocol, so they're easy to
+// inspect and can be replayed through redigo's own parser.
+const recordExt = ".resp"
+
+// recordingConn is a connection which saves a copy of every command sent with
+// Do, and its reply, to the recording for the current interval.
+type recordingConn struct {
+	redis.Conn
+	dir string
+	f   *os.File
+	w   *bufio.Writer
+}
+
+func newRecordingConn(conn redis.Conn, name string) (*recordingConn, error) {
+	dir := filepath.Join(*recordDir, url.PathEscape(name))
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingConn{Conn: conn, dir: dir}, nil
+}
+
+// cycle starts a new recording, for the interval starting at t.
+func (c *recordingConn) cycle(t time.Time) error {
+	err := c.flush()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(c.dir, fmt.Sprintf("%d%s", t.Unix(), recordExt)))
+	if err != nil {
+		return err
+	}
+
+	c.f = f
+	c.w = bufio.NewWriter(f)
+	return nil
+}
+
+func (c *recordingConn) flush() error {
+	if c.f == nil {
+		return nil
+	}
+
+	err := c.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = c.f.Close()
+	c.f = nil
+	return err
+}
+
+func (c *recordingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+
+	if c.w != nil {
+		cmdline := []interface{}{[]byte(cmd)}
+		for _, a := range args {
+			cmdline = append(cmdline, []byte(fmt.Sprint(a)))
+		}
+
+		writeRESP(c.w, cmdline)
+		if re, ok := err.(redis.Error); ok {
+			writeRESP(c.w, re)
+		} else {
+			writeRESP(c.w, reply)
+		}
+
+		// Flush every reply, so that the recording is usable even if the
+		// collector is killed mid-interval.
+		c.w.Flush()
+	}
+
+	return reply, err
+}
+
+func (c *recordingConn) Close() error {
+	c.flush()
+	return c.Conn.Close()
+}
+
+// writeRESP encodes a reply, as returned by redigo, in the Redis protocol.
+func writeRESP(w io.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		fmt.Fprint(w, "$-1\r\n")
+
+	case redis.Error:
+		fmt.Fprintf(w, "-%s\r\n", strings.Replace(string(v), "\r\n", " ", -1))
+
+	case string:
+		fmt.Fprintf(w, "+%s\r\n", v)
+
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, vv := range v {
+			writeRESP(w, vv)
+		}
+
+	default:
+		b := []byte(fmt.Sprint(v))
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(b), b)
+	}
+}
+
+// replayConn is a fake connection which answers commands from a recording.
+// Each command is answered with the next recorded reply to the same command,
+// so collection must send them in the same order as when recording.
+type replayConn struct {
+	dir     string
+	entries []replayEntry
+	next    int
+}
+
+type replayEntry struct {
+	cmdline string
+	reply   interface{}
+	err     error
+}
+
+// cycle loads the recording of the interval starting at t.
+func (c *replayConn) cycle(t time.Time) error {
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, fmt.Sprintf("%d%s", t.Unix(), recordExt)))
+	if err != nil {
+		return err
+	}
+
+	c.entries = nil
+	c.next = 0
+
+	r := redis.NewConn(&bufferConn{Reader: bytes.NewReader(b)}, 0, 0)
+	for {
+		cmd, err := redis.Strings(r.Receive())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("bad recording: %s", err)
+		}
+
+		reply, err := r.Receive()
+		if _, ok := err.(redis.Error); !ok && err != nil {
+			return fmt.Errorf("bad recording: %s", err)
+		}
+
+		c.entries = append(c.entries, replayEntry{strings.Join(cmd, " "), reply, err})
+	}
+
+	return nil
+}
+
+func (c *replayConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	cmdline := []string{cmd}
+	for _, a := range args {
+		cmdline = append(cmdline, fmt.Sprint(a))
+	}
+
+	s := strings.Join(cmdline, " ")
+	for i := c.next; i < len(c.entries); i++ {
+		if c.entries[i].cmdline == s {
+			c.next = i + 1
+			return c.entries[i].reply, c.entries[i].err
+		}
+	}
+
+	return nil, fmt.Errorf("not in recording: %s", s)
+}
+
+func (c *replayConn) Send(cmd string, args ...interface{}) error {
+	return fmt.Errorf("replay doesn't support pipelining")
+}
+
+func (c *replayConn) Flush() error {
+	return nil
+}
+
+func (c *replayConn) Receive() (interface{}, error) {
+	return nil, fmt.Errorf("replay doesn't support pipelining")
+}
+
+func (c *replayConn) Err() error {
+	return nil
+}
+
+func (c *replayConn) Close() error {
+	return nil
+}
+
+// bufferConn is a net.Conn which reads from a buffer, so redigo can parse
+// replies from a file.
+type bufferConn struct {
+	net.Conn
+	*bytes.Reader
+}
+
+func (c *bufferConn) Read(b []byte) (int, error) {
+	return c.Reader.Read(b)
+}
+
+func (c *bufferConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *bufferConn) Close() error {
+	return nil
+}
+
+func (c *bufferConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *bufferConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *bufferConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// getReplayTargets returns a target for each recording in dir.
+func getReplayTargets(dir string) (*Targets, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &Targets{}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+
+		name, err := url.PathUnescape(fi.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		rc := &replayConn{dir: filepath.Join(dir, fi.Name())}
+		err = ts.Add(&Target{
+			Name: name,
+			Host: "replay",
+			dial: func() (redis.Conn, error) {
+				return rc, nil
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ts, nil
+}
+
+// replayTimes returns the start time of every recorded interval in dir, in
+// order.
+func replayTimes(dir string) ([]time.Time, error) {
+	seen := map[int64]bool{}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*"+recordExt))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range paths {
+		n, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(p), recordExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seen[n] = true
+	}
+
+	ns := make([]int64, 0, len(seen))
+	for n := range seen {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i] < ns[j] })
+
+	ts := make([]time.Time, len(ns))
+	for i, n := range ns {
+		ts[i] = time.Unix(n, 0)
+	}
+
+	return ts, nil
+}
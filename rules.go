@@ -0,0 +1,316 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	includes = listFlag{}
+	excludes = listFlag{}
+	renames  = mapFlag{}
+)
+
+func init() {
+	flag.Var(&includes, "include", "only report metrics matching this section/name pattern, or with -exclude, make exceptions to it (repeatable)")
+	flag.Var(&excludes, "exclude", "don't report metrics matching this section/name pattern (repeatable)")
+	flag.Var(renames, "rename", "report metrics matching a section/name pattern under another name, as pattern=section/name (repeatable)")
+}
+
+// Filter and rename rules match metrics by their full name, which is the
+// section and the metric name, e.g. commandstats/cmdstat_get/calls. Patterns
+// are globs, where * matches any run of characters (including slashes), ?
+// matches one, and [abc] (or [!abc], or [a-z]) matches one of a class, or
+// regular expressions if they begin with ~. Either way, the pattern must match
+// the whole name.
+//
+// If there are only includes, just the matching metrics are reported. If there
+// are only excludes, everything but the matching metrics is. If there are
+// both, the excludes are applied first, and then the includes make exceptions
+// to them, e.g. to drop all of the commandstats except for GET and SET:
+//
+//	-exclude 'commandstats/*' -include 'commandstats/cmdstat_[gs]et/*'
+type pattern struct {
+	// How the pattern is matched, cheapest first: the whole name, a prefix
+	// of it, or a regexp.
+	literal string
+	prefix  string
+	re      *regexp.Regexp
+
+	// The section which every name the pattern matches is in, if known.
+	section string
+
+	// What metrics matching the pattern are renamed to.
+	to string
+}
+
+func compilePattern(s string) (*pattern, error) {
+	p := &pattern{}
+
+	if strings.HasPrefix(s, "~") {
+		_, err := regexp.Compile(s[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s: %s", s, err)
+		}
+
+		p.re = regexp.MustCompile("^(?:" + s[1:] + ")$")
+		return p, nil
+	}
+
+	// The section is known if it comes before the first wildcard.
+	i := strings.IndexAny(s, "*?[")
+	if j := strings.IndexByte(s, '/'); j >= 0 && (i < 0 || j < i) {
+		p.section = s[:j]
+	}
+
+	switch {
+	case i < 0:
+		p.literal = s
+
+	case i == len(s)-1 && s[i] == '*':
+		p.prefix = s[:i]
+
+	default:
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			case '[':
+				j := strings.IndexByte(s[i+1:], ']')
+				if j <= 0 {
+					return nil, fmt.Errorf("invalid pattern: %s: unterminated [", s)
+				}
+
+				class := s[i+1 : i+1+j]
+				b.WriteByte('[')
+				if class[0] == '!' {
+					b.WriteByte('^')
+					class = class[1:]
+				}
+				b.WriteString(class)
+				b.WriteByte(']')
+				i += j + 1
+			default:
+				b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+			}
+		}
+
+		re, err := regexp.Compile("^" + b.String() + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s: %s", s, err)
+		}
+		p.re = re
+	}
+
+	return p, nil
+}
+
+func (p *pattern) match(name string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+
+	case p.prefix != "":
+		return strings.HasPrefix(name, p.prefix)
+	}
+
+	return name == p.literal
+}
+
+// rename returns the new full name of name, which p matches.
+func (p *pattern) rename(name string) string {
+	if p.re != nil {
+		return p.re.ReplaceAllString(name, p.to)
+	}
+
+	return p.to
+}
+
+// A ruleIndex is a set of patterns, indexed so that only the ones which could
+// match a given metric are tried.
+type ruleIndex struct {
+	literal   map[string]*pattern
+	bySection map[string][]*pattern
+	any       []*pattern
+}
+
+func (ix *ruleIndex) add(p *pattern) {
+	switch {
+	case p.literal != "":
+		if ix.literal == nil {
+			ix.literal = map[string]*pattern{}
+		}
+		ix.literal[p.literal] = p
+
+	case p.section != "":
+		if ix.bySection == nil {
+			ix.bySection = map[string][]*pattern{}
+		}
+		ix.bySection[p.section] = append(ix.bySection[p.section], p)
+
+	default:
+		ix.any = append(ix.any, p)
+	}
+}
+
+func (ix *ruleIndex) empty() bool {
+	return len(ix.literal) == 0 && len(ix.bySection) == 0 && len(ix.any) == 0
+}
+
+// find returns the first pattern which matches the full name, or nil.
+func (ix *ruleIndex) find(section, name string) *pattern {
+	if p, ok := ix.literal[name]; ok {
+		return p
+	}
+
+	for _, p := range ix.bySection[section] {
+		if p.match(name) {
+			return p
+		}
+	}
+
+	for _, p := range ix.any {
+		if p.match(name) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// rulesOutput applies the filter and rename rules to the metrics, before
+// passing them on to the real output.
+type rulesOutput struct {
+	Output
+	include ruleIndex
+	exclude ruleIndex
+	rename  ruleIndex
+
+	// The outcome for each metric, since the same ones are seen every
+	// interval. Keyed by section and name.
+	results map[[2]string]ruleResult
+
+	// Reused every interval, to avoid reallocating it.
+	kept Metrics
+}
+
+type ruleResult struct {
+	drop         bool
+	section, key string
+}
+
+// withRules wraps out to apply the rules given by -include, -exclude and
+// -rename. If there aren't any, it returns out as it is.
+func withRules(out Output) (Output, error) {
+	return newRulesOutput(out, includes, excludes, renames)
+}
+
+// newRulesOutput wraps out to apply the given rules, or returns out if there
+// aren't any.
+func newRulesOutput(out Output, inc, exc []string, ren map[string]string) (Output, error) {
+	o := &rulesOutput{Output: out}
+
+	for _, list := range []struct {
+		srcs []string
+		ix   *ruleIndex
+	}{
+		{inc, &o.include},
+		{exc, &o.exclude},
+	} {
+		for _, s := range list.srcs {
+			p, err := compilePattern(s)
+			if err != nil {
+				return nil, err
+			}
+			list.ix.add(p)
+		}
+	}
+
+	// Renames are tried in order of pattern, so that the result doesn't
+	// depend on the order of the flags.
+	srcs := make([]string, 0, len(ren))
+	for s := range ren {
+		srcs = append(srcs, s)
+	}
+	sort.Strings(srcs)
+
+	for _, s := range srcs {
+		p, err := compilePattern(s)
+		if err != nil {
+			return nil, err
+		}
+
+		p.to = ren[s]
+		o.rename.add(p)
+	}
+
+	if o.include.empty() && o.exclude.empty() && o.rename.empty() {
+		return out, nil
+	}
+
+	return o, nil
+}
+
+func (o *rulesOutput) Write(t time.Time, ms Metrics) error {
+	o.kept = o.kept[:0]
+
+	for _, m := range ms {
+		name := m.Name()
+		k := [2]string{m.Section, name}
+
+		r, ok := o.results[k]
+		if !ok {
+			r = o.evaluate(m.Section, name)
+			if o.results == nil || len(o.results) >= maxInterned {
+				o.results = make(map[[2]string]ruleResult)
+			}
+			o.results[k] = r
+		}
+
+		if r.drop {
+			continue
+		}
+
+		if r.key != "" {
+			m.Section, m.Prefix, m.Key = r.section, "", r.key
+		}
+
+		o.kept = append(o.kept, m)
+	}
+
+	return o.Output.Write(t, o.kept)
+}
+
+// evaluate applies the rules to the metric with the given section and name.
+func (o *rulesOutput) evaluate(section, name string) ruleResult {
+	full := section + "/" + name
+
+	excluded := o.exclude.find(section, full) != nil
+	if o.exclude.empty() {
+		excluded = !o.include.empty()
+	}
+
+	if excluded && o.include.find(section, full) == nil {
+		return ruleResult{drop: true}
+	}
+
+	p := o.rename.find(section, full)
+	if p == nil {
+		return ruleResult{}
+	}
+
+	to := p.rename(full)
+	i := strings.IndexByte(to, '/')
+	if i < 0 {
+		return ruleResult{section: section, key: intern(to)}
+	}
+
+	return ruleResult{section: intern(to[:i]), key: intern(to[i+1:])}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+var (
+	goMaxProcs  = flag.Int("gomaxprocs", 0, "maximum number of cpus to run on at once (overrides GOMAXPROCS)")
+	memoryLimit = flag.String("memory-limit", "", "soft limit on the collector's memory use, e.g. 64MiB, which makes the gc work harder as it's approached (overrides GOMEMLIMIT)")
+	gcPercent   = flag.Int("gc-percent", 100, "how much the heap can grow between garbage collections, as a percentage, or -1 to collect only at the -memory-limit (overrides GOGC)")
+)
+
+// tuneRuntime applies the runtime flags. Only the ones which were actually
+// given (on the command line or in the config file) are applied, so that the
+// usual environment variables still work otherwise.
+func tuneRuntime() error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		switch f.Name {
+		case "gomaxprocs":
+			if *goMaxProcs < 1 {
+				err = fmt.Errorf("-gomaxprocs must be at least 1")
+				return
+			}
+			runtime.GOMAXPROCS(*goMaxProcs)
+
+		case "memory-limit":
+			var n int64
+			n, err = parseBytes(*memoryLimit)
+			if err != nil {
+				err = fmt.Errorf("-memory-limit: %s", err)
+				return
+			}
+			debug.SetMemoryLimit(n)
+
+		case "gc-percent":
+			debug.SetGCPercent(*gcPercent)
+		}
+	})
+
+	return err
+}
+
+var byteSuffixes = []struct {
+	suffix string
+	n      int64
+}{
+	// Longest first, so that e.g. "MiB" isn't taken for "B".
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// parseBytes parses a size in bytes, with an optional unit.
+func parseBytes(orig string) (int64, error) {
+	s := strings.TrimSpace(orig)
+	mult := int64(1)
+	for _, u := range byteSuffixes {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", orig)
+	}
+
+	return n * mult, nil
+}
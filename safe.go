@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	safeMode = flag.Bool("safe", true, "refuse to send redis any command which isn't known to be read-only")
+)
+
+// The commands which the collector may send in safe mode. None of them can
+// change the data, or the server's configuration, so a collector running in
+// safe mode can be audited as read-only. Commands with subcommands are listed
+// with the subcommand, since e.g. CONFIG GET is safe but CONFIG SET isn't.
+var safeCommands = setOf(
+	"PING", "AUTH", "SELECT", "ASKING", "INFO", "ROLE", "DBSIZE", "COMMAND", "COMMAND COUNT", "COMMAND INFO",
+	"CLIENT LIST", "CLIENT INFO", "CONFIG GET",
+	"SLOWLOG GET", "SLOWLOG LEN",
+	"LATENCY LATEST", "LATENCY HISTORY",
+	"MEMORY STATS", "MEMORY USAGE", "MEMORY DOCTOR",
+	"CLUSTER INFO", "CLUSTER NODES", "CLUSTER SLOTS", "CLUSTER SHARDS", "CLUSTER LINKS", "CLUSTER MYID",
+	"SCAN", "TYPE", "TTL", "PTTL", "EXISTS", "STRLEN", "LLEN", "HLEN", "SCARD", "ZCARD", "XLEN",
+	"FUNCTION STATS", "FUNCTION LIST",
+	"OBJECT ENCODING", "OBJECT FREQ", "OBJECT IDLETIME",
+	"XINFO STREAM", "XINFO GROUPS", "XINFO CONSUMERS",
+	"PSUBSCRIBE", "PUNSUBSCRIBE",
+	"PUBSUB CHANNELS", "PUBSUB NUMSUB", "PUBSUB NUMPAT", "PUBSUB SHARDCHANNELS", "PUBSUB SHARDNUMSUB",
+	"SENTINEL MASTERS", "SENTINEL MASTER", "SENTINEL REPLICAS", "SENTINEL SENTINELS", "SENTINEL GET-MASTER-ADDR-BY-NAME",
+)
+
+// The commands which are only identified by their first argument.
+var containerCommands = setOf(
+	"CLIENT", "CONFIG", "SLOWLOG", "LATENCY", "MEMORY", "CLUSTER", "OBJECT", "XINFO", "PUBSUB", "SENTINEL",
+	"COMMAND", "SCRIPT", "FUNCTION", "ACL", "MODULE", "DEBUG",
+)
+
+// commandName returns the name of the command, including its subcommand (if
+// it has them), in upper case.
+func commandName(cmd string, args ...interface{}) string {
+	name := strings.ToUpper(cmd)
+	if containerCommands[name] && len(args) > 0 {
+		name += " " + strings.ToUpper(fmt.Sprint(args[0]))
+	}
+
+	return name
+}
+
+// checkSafe returns an error if safe mode is on, and any of the commands (as
+// returned by commandName) isn't allowed by it.
+func checkSafe(cmds []string) error {
+	if !*safeMode {
+		return nil
+	}
+
+	for _, c := range cmds {
+		if !safeCommands[c] {
+			return fmt.Errorf("%s isn't a read-only command, so can't be sent in safe mode (see -safe)", c)
+		}
+	}
+
+	return nil
+}
+
+// requiredCommands returns the commands which the collector will send, given
+// the flags.
+func requiredCommands() []string {
+	cmds := []string{"PING", "INFO"}
+	if *clusterDiscover {
+		cmds = append(cmds, "CLUSTER NODES")
+	}
+
+	if *sentinelMaster != "" {
+		cmds = append(cmds, "SENTINEL GET-MASTER-ADDR-BY-NAME", "SENTINEL MASTER")
+	}
+
+	for _, c := range enabledCollectors() {
+		cmds = append(cmds, c.commands...)
+	}
+
+	return cmds
+}
+
+// safeConn is a connection which refuses to send commands which aren't
+// allowed in safe mode. The commands are checked when the flags are loaded, so
+// this is a last line of defence against a bug letting one through.
+type safeConn struct {
+	redis.Conn
+}
+
+func (c *safeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	// An empty command just flushes and receives pending replies.
+	if cmd == "" {
+		return c.Conn.Do(cmd, args...)
+	}
+
+	err := checkSafe([]string{commandName(cmd, args...)})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Conn.Do(cmd, args...)
+}
+
+func (c *safeConn) Send(cmd string, args ...interface{}) error {
+	err := checkSafe([]string{commandName(cmd, args...)})
+	if err != nil {
+		return err
+	}
+
+	return c.Conn.Send(cmd, args...)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	schedule = mapFlag{}
+)
+
+func init() {
+	flag.Var(schedule, "schedule", "how often to run a collector, as name=duration, e.g. info=10s (repeatable)")
+}
+
+// Every collector (including "info", which fetches INFO) runs on its own
+// schedule for each target. By default they run every interval (or as often
+// as the collector says, for the ones which shouldn't run so often), but the
+// -schedule flag (or the schedule option of the config file) can change that
+// for all targets, and a target's own schedule for just that target.
+//
+// The main loop wakes up whenever something is due, runs INFO itself, and
+// starts the background collectors (see collectors.go).
+
+// A scheduled is the schedule of one collector for one target.
+type scheduled struct {
+	every    time.Duration
+	next     time.Time
+	last     time.Time
+	duration time.Duration
+	err      error
+	lastOK   time.Time
+}
+
+// scheduleStatus is how a schedule is reported by the admin api.
+type scheduleStatus struct {
+	Every        string     `json:"every"`
+	NextRun      time.Time  `json:"next_run"`
+	LastRun      *time.Time `json:"last_run"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastSuccess  *time.Time `json:"last_success"`
+}
+
+// checkSchedule returns an error if the schedule s names a collector which
+// doesn't exist (or isn't enabled), or has a duration which isn't positive.
+func checkSchedule(s map[string]string) error {
+	names := map[string]bool{"info": true}
+	for _, c := range enabledCollectors() {
+		names[c.name] = true
+	}
+
+	for name, v := range s {
+		if !names[name] {
+			return fmt.Errorf("no such collector (or it isn't enabled): %s", name)
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("%s: must be greater than zero", name)
+		}
+	}
+
+	return nil
+}
+
+// every returns how often the named collector runs for the target, or def if
+// no schedule says.
+func (t *Target) every(name string, def time.Duration) time.Duration {
+	for _, s := range []map[string]string{t.Schedule, schedule} {
+		if v, ok := s[name]; ok {
+			d, err := time.ParseDuration(v)
+			if err == nil {
+				return d
+			}
+		}
+	}
+
+	return def
+}
+
+// due returns true if the named collector is due to run at now, and if so,
+// schedules the next run.
+func (t *Target) due(name string, every time.Duration, now time.Time) bool {
+	t.schedMu.Lock()
+	defer t.schedMu.Unlock()
+
+	if t.sched == nil {
+		t.sched = map[string]*scheduled{}
+	}
+
+	s, ok := t.sched[name]
+	if !ok {
+		s = &scheduled{next: now}
+		t.sched[name] = s
+	}
+
+	s.every = every
+	if now.Before(s.next) {
+		return false
+	}
+
+	// Runs are scheduled from when they were due rather than when they
+	// happened, so they don't drift, unless they've fallen behind.
+	s.next = s.next.Add(every)
+	if s.next.Before(now) {
+		s.next = now.Add(every)
+	}
+
+	return true
+}
+
+// ran records that the named collector ran from start until now.
+func (t *Target) ran(name string, start time.Time, err error) {
+	t.schedMu.Lock()
+	defer t.schedMu.Unlock()
+
+	if s, ok := t.sched[name]; ok {
+		s.last = start
+		s.duration = time.Since(start)
+		s.err = err
+		if err == nil {
+			s.lastOK = start
+		}
+	}
+}
+
+// nextRun returns when the next of the target's collectors is due, or zero if
+// none have been scheduled yet.
+func (t *Target) nextRun() time.Time {
+	t.schedMu.Lock()
+	defer t.schedMu.Unlock()
+
+	var next time.Time
+	for _, s := range t.sched {
+		if next.IsZero() || s.next.Before(next) {
+			next = s.next
+		}
+	}
+
+	return next
+}
+
+// status returns the schedules of the target's collectors, by name.
+func (t *Target) status() map[string]scheduleStatus {
+	t.schedMu.Lock()
+	defer t.schedMu.Unlock()
+
+	st := make(map[string]scheduleStatus, len(t.sched))
+	for name, s := range t.sched {
+		ss := scheduleStatus{
+			Every:   s.every.String(),
+			NextRun: s.next,
+		}
+
+		if !s.last.IsZero() {
+			last := s.last
+			ss.LastRun = &last
+			ss.LastDuration = s.duration.String()
+		}
+
+		if s.err != nil {
+			ss.LastError = s.err.Error()
+		}
+
+		if !s.lastOK.IsZero() {
+			ok := s.lastOK
+			ss.LastSuccess = &ok
+		}
+
+		st[name] = ss
+	}
+
+	return st
+}
+
+// Status returns the schedules of every target's collectors, by target name.
+func (ts *Targets) Status() map[string]map[string]scheduleStatus {
+	st := map[string]map[string]scheduleStatus{}
+	for _, t := range ts.all() {
+		st[t.Name] = t.status()
+	}
+
+	return st
+}
+
+// NextRun returns when the next collector of any active target is due, or
+// def if that's sooner (or nothing has been scheduled yet).
+func (ts *Targets) NextRun(def time.Time) time.Time {
+	next := def
+	for _, t := range ts.Active() {
+		n := t.nextRun()
+		if !n.IsZero() && n.Before(next) {
+			next = n
+		}
+	}
+
+	return next
+}
+
+// runScheduled runs every collector which is due at now, for every active
+// target. INFO is collected (and written) before returning, and the
+// background collectors are started, unless they're blocked. If more than one
+// target was collected, so is the skew between them (see skew.go).
+func runScheduled(now time.Time, targets *Targets, out Output, interval time.Duration, cs []*collector, results chan<- collected) {
+	var first, last time.Time
+	collected := 0
+	for _, tg := range targets.Active() {
+		if tg.due("info", tg.every("info", interval), now) {
+			start := time.Now()
+			collectTarget(now, tg, out)
+
+			if collected == 0 {
+				first = start
+			}
+			last = start
+			collected++
+		}
+
+		for _, c := range cs {
+			if !tg.runs(c.name) {
+				continue
+			}
+
+			def := c.every
+			if def == 0 {
+				def = interval
+			}
+
+			if !tg.due(c.name, tg.every(c.name, def), now) {
+				continue
+			}
+
+			if cmd := tg.blockedBy(c); cmd != "" {
+				tg.skip(now, c, fmt.Sprintf("needs %s, which is blocked", cmd), out)
+				continue
+			}
+
+			if err := tg.denied(c.name); err != nil {
+				tg.skip(now, c, fmt.Sprintf("permission: %s", err), out)
+				continue
+			}
+
+			tg.Background(now, c, results)
+		}
+	}
+
+	if collected > 1 {
+		writeSkew(now, last.Sub(first), interval, out)
+	}
+
+	writeAggregates(now, interval, targets, out)
+}
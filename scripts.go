@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	scriptStats = flag.Bool("scripts", false, "report whether a script or function is running, and for how long, and how many functions each engine has (redis 7 and later)")
+)
+
+// A script which runs for too long blocks the server, so whether one is
+// running (and how long it's been running for) is worth watching. INFO only
+// says how many scripts are cached and how much memory they use (in the
+// memory section), so the rest comes from FUNCTION STATS, which the server
+// answers even while it's busy with a script. It covers EVAL scripts as well
+// as functions. Older servers don't have it, so there's nothing to report.
+
+// scriptsCollector reports, in the scripts section, whether a script is
+// running, how long for, and how many libraries and functions are loaded into
+// each engine.
+var scriptsCollector = &collector{
+	name:     "scripts",
+	commands: []string{"FUNCTION STATS"},
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		stats, err := redis.Values(conn.Do("FUNCTION", "STATS"))
+		if re, ok := err.(redis.Error); ok && strings.Contains(strings.ToLower(string(re)), "unknown command") {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		ms := make(Metrics, 0)
+		add := func(prefix, key string, n int64) {
+			ms = append(ms, &Metric{Section: "scripts", Prefix: prefix, Key: key, Value: strconv.FormatInt(n, 10)})
+		}
+
+		fields, err := valueMap(stats)
+		if err != nil {
+			return nil, err
+		}
+
+		running, duration := int64(0), int64(0)
+		if fields["running_script"] != nil {
+			script, err := valueMap(fields["running_script"])
+			if err != nil {
+				return nil, err
+			}
+
+			running = 1
+			duration, _ = redis.Int64(script["duration_ms"], nil)
+		}
+
+		add("", "running", running)
+		add("", "running_duration_ms", duration)
+
+		engines, err := valueMap(fields["engines"])
+		if err != nil {
+			return nil, err
+		}
+
+		for name, v := range engines {
+			engine, err := valueMap(v)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, k := range []string{"libraries_count", "functions_count"} {
+				if n, err := redis.Int64(engine[k], nil); err == nil {
+					add(strings.ToLower(name), k, n)
+				}
+			}
+		}
+
+		return ms, nil
+	},
+}
+
+// valueMap converts a reply which is an array of alternating names and values
+// into a map, without converting the values (like redis.StringMap does), since
+// they can be arrays themselves.
+func valueMap(reply interface{}) (map[string]interface{}, error) {
+	vs, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{}, len(vs)/2)
+	for i := 0; i+1 < len(vs); i += 2 {
+		k, err := redis.String(vs[i], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		m[k] = vs[i+1]
+	}
+
+	return m, nil
+}
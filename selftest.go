@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// When the metrics of a target look incomplete, the first question is what
+// the collector can see of it. Running with the selftest argument (after the
+// flags, e.g. collectd-more-redis -config c.yaml selftest) answers it: instead
+// of collecting, it connects to each target once, and reports which sections
+// INFO has, then runs each enabled background collector once, and reports
+// whether it worked, was skipped (and why), or failed, with the ACLs or
+// unknown commands called out. It then estimates how many commands each
+// interval costs the target, and how long they take, from how often each
+// collector runs. Nothing is written to the output.
+
+// A countingConn is a connection which counts the commands which are sent on
+// it, and how long they take.
+type countingConn struct {
+	redis.Conn
+	commands int
+	spent    time.Duration
+}
+
+func (c *countingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		c.commands++
+	}
+
+	start := time.Now()
+	reply, err := c.Conn.Do(cmd, args...)
+	c.spent += time.Since(start)
+	return reply, err
+}
+
+func (c *countingConn) Send(cmd string, args ...interface{}) error {
+	c.commands++
+	return c.Conn.Send(cmd, args...)
+}
+
+// selfTest tests every target, and writes what it found to w.
+func selfTest(w io.Writer, targets *Targets, interval time.Duration, cs []*collector) {
+	for _, t := range targets.all() {
+		selfTestTarget(w, t, interval, cs)
+	}
+}
+
+// selfTestTarget tests one target.
+func selfTestTarget(w io.Writer, t *Target, interval time.Duration, cs []*collector) {
+	fmt.Fprintf(w, "%s (%s):\n", t.Name, t.Addr())
+
+	conn, err := t.connect()
+	if err != nil {
+		fmt.Fprintf(w, "  can't connect: %s\n", err)
+		return
+	}
+	defer conn.Close()
+
+	info := &countingConn{Conn: conn}
+	blob, err := fetchInfo(info)
+	if err != nil {
+		fmt.Fprintf(w, "  can't fetch INFO: %s\n", err)
+		return
+	}
+
+	ms, err := parseInfo(blob)
+	if err != nil {
+		fmt.Fprintf(w, "  can't parse INFO: %s\n", err)
+		return
+	}
+
+	fields := map[string]int{}
+	version, role, mode := "", "", ""
+	for _, m := range ms {
+		fields[m.Section]++
+		switch {
+		case m.Section == "server" && m.Key == "redis_version":
+			version = m.Value
+		case m.Section == "server" && m.Key == "redis_mode":
+			mode = m.Value
+		case m.Section == "replication" && m.Key == "role":
+			role = m.Value
+		}
+	}
+
+	t.mu.Lock()
+	t.role = roleOf(role, mode)
+	t.mu.Unlock()
+
+	sections := make([]string, 0, len(fields))
+	for s, n := range fields {
+		sections = append(sections, fmt.Sprintf("%s (%d)", s, n))
+	}
+
+	sort.Strings(sections)
+	fmt.Fprintf(w, "  redis %s, as %s\n", version, t.role)
+	fmt.Fprintf(w, "  INFO sections (and fields): %s\n", strings.Join(sections, ", "))
+
+	// The cost of each interval: INFO, and the collectors' share.
+	perInterval := float64(info.commands)
+	spent := info.spent.Seconds()
+
+	for _, c := range cs {
+		why := selfTestSkip(t, c)
+		if why != "" {
+			fmt.Fprintf(w, "  %s: skipped: %s\n", c.name, why)
+			continue
+		}
+
+		every := c.every
+		if every == 0 {
+			every = interval
+		}
+
+		every = t.every(c.name, every)
+
+		n, d, err := selfTestCollector(t, c)
+		switch {
+		case noPerm(err):
+			fmt.Fprintf(w, "  %s: not allowed by the ACLs: %s\n", c.name, err)
+		case err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command"):
+			fmt.Fprintf(w, "  %s: not supported by the server: %s\n", c.name, err)
+		case err != nil:
+			fmt.Fprintf(w, "  %s: failed: %s\n", c.name, err)
+		default:
+			fmt.Fprintf(w, "  %s: ok, %d commands in %s, every %s\n", c.name, n, d.Round(time.Microsecond), every)
+		}
+
+		share := interval.Seconds() / every.Seconds()
+		if share > 1 {
+			share = 1
+		}
+
+		perInterval += float64(n) * share
+		spent += d.Seconds() * share
+	}
+
+	fmt.Fprintf(w, "  each interval (%s): about %.0f commands, in %s\n", interval, perInterval, time.Duration(spent*float64(time.Second)).Round(time.Microsecond))
+}
+
+// selfTestSkip returns why the collector wouldn't run for the target, or ""
+// if it would.
+func selfTestSkip(t *Target, c *collector) string {
+	if !t.enabled(c.name) {
+		return "not one of the target's collectors"
+	}
+
+	if !profileRuns(t.role, c.name) {
+		return fmt.Sprintf("not in the profile for %s", t.role)
+	}
+
+	if cmd := t.blockedBy(c); cmd != "" {
+		return fmt.Sprintf("needs %s, which is blocked", cmd)
+	}
+
+	if t.dial != nil {
+		return "can't run without a connection of its own"
+	}
+
+	return ""
+}
+
+// selfTestCollector runs the collector once for the target, on a connection of
+// its own, and returns how many commands it sent, and how long it took.
+func selfTestCollector(t *Target, c *collector) (int, time.Duration, error) {
+	conn, err := dialRedis(t.Host, t.Port, t.ConnOptions, t.wrap)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	counted := &countingConn{Conn: conn}
+	b := &backgroundRun{c: c, target: t, collect: c.collect, conn: counted}
+	if c.newCollect != nil {
+		b.collect = c.newCollect(t)
+	}
+
+	b.mu.Lock()
+	defer b.close()
+	defer b.mu.Unlock()
+
+	start := time.Now()
+	_, err = b.collect(b.collectConn(), start)
+	return counted.commands, time.Since(start), err
+}
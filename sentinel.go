@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	sentinelAddrs    = listFlag{}
+	sentinelMaster   = flag.String("sentinel-master", "", "the name of the master to ask -sentinel for the address of, and follow through failovers (instead of -host and -port)")
+	sentinelPassword = flag.String("sentinel-password", "", "the password of the sentinels, if they have one")
+)
+
+func init() {
+	flag.Var(&sentinelAddrs, "sentinel", "the host:port of a sentinel to ask for the address of -sentinel-master (repeatable, tried in order)")
+}
+
+// A target can be a master monitored by Redis Sentinel, given by its name,
+// rather than by its address. The collector connects to the first of its
+// sentinels which answers, and asks it for the master's address each
+// interval. After a failover (when the address changes), it reconnects to
+// the new master, so the metrics keep coming from whichever server is the
+// master, under the same instance.
+//
+// The sentinel's own metrics (from INFO sentinel) are reported too, in the
+// sentinel section, along with the quorum of the master, how many replicas
+// and other sentinels it knows of, and how many failovers have been followed.
+
+// sentinelConn returns the connection to a sentinel of the target, connecting
+// to the first which answers if there isn't one. It must be called with t.mu
+// held.
+func (t *Target) sentinelConn() (redis.Conn, error) {
+	if t.sentinel != nil {
+		return t.sentinel, nil
+	}
+
+	// Sentinels don't have databases, or (usually) the password of the
+	// servers they monitor.
+	o := t.ConnOptions
+	o.Socket = ""
+	db, user, pass := 0, "", *sentinelPassword
+	o.DB, o.Username, o.Password = &db, &user, &pass
+
+	var err error
+	for _, addr := range t.Sentinels {
+		host, port, e := splitHostPort(addr)
+		if e != nil {
+			return nil, e
+		}
+
+		t.sentinel, err = dialRedis(host, port, o, t.wrap)
+		if err == nil {
+			return t.sentinel, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no sentinel of %s answered: %s", t.SentinelMaster, err)
+}
+
+// resolveMaster asks a sentinel for the address of the target's master. It
+// must be called with t.mu held.
+func (t *Target) resolveMaster() (string, error) {
+	conn, err := t.sentinelConn()
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := redis.Strings(conn.Do("SENTINEL", "GET-MASTER-ADDR-BY-NAME", t.SentinelMaster))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("sentinel doesn't know of master: %s", t.SentinelMaster)
+	}
+
+	if err != nil {
+		t.dropSentinel()
+		return "", err
+	}
+
+	if len(addr) != 2 {
+		return "", fmt.Errorf("bad reply to SENTINEL GET-MASTER-ADDR-BY-NAME: %v", addr)
+	}
+
+	return net.JoinHostPort(addr[0], addr[1]), nil
+}
+
+// followMaster checks whether the target's master has moved, and if so,
+// drops the connections to the old one (the target's, and the background
+// collectors', once they're done), so the next are to the new one. It must be
+// called with t.mu held.
+func (t *Target) followMaster() error {
+	addr, err := t.resolveMaster()
+	if err != nil {
+		return err
+	}
+
+	if t.master != "" && addr != t.master {
+		fmt.Printf("# master %s moved from %s to %s\n", t.SentinelMaster, t.master, addr)
+		t.failovers++
+
+		if t.conn != nil {
+			t.conn.Close()
+			t.conn = nil
+		}
+
+		for _, b := range t.background {
+			go b.close()
+		}
+
+		for _, fn := range t.movers {
+			fn()
+		}
+	}
+
+	t.master = addr
+	return nil
+}
+
+// dropSentinel closes the connection to the sentinel, so that the next is to
+// whichever answers first.
+func (t *Target) dropSentinel() {
+	if t.sentinel != nil {
+		t.sentinel.Close()
+		t.sentinel = nil
+	}
+}
+
+// sentinelMetrics returns the metrics of the sentinel, and what it knows of
+// the target's master. It must be called with t.mu held.
+func (t *Target) sentinelMetrics(instance string) (Metrics, error) {
+	conn, err := t.sentinelConn()
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := redis.Bytes(conn.Do("INFO", "sentinel"))
+	if err != nil {
+		t.dropSentinel()
+		return nil, err
+	}
+
+	ms, err := parseInfo(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := redis.StringMap(conn.Do("SENTINEL", "MASTER", t.SentinelMaster))
+	if err != nil {
+		return nil, err
+	}
+
+	add := func(key, value string) {
+		ms = append(ms, &Metric{Section: "sentinel", Prefix: "master", Key: key, Value: value})
+	}
+
+	add("quorum", info["quorum"])
+	add("known_replicas", info["num-slaves"])
+	add("known_sentinels", info["num-other-sentinels"])
+	add("failovers", strconv.FormatInt(t.failovers, 10))
+
+	for _, m := range ms {
+		m.Instance = instance
+	}
+
+	return ms, nil
+}
+
+// splitHostPort splits an address into its host and port.
+func splitHostPort(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad port: %s", addr)
+	}
+
+	return host, port, nil
+}
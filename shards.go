@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// In a cluster, each key is stored by the master which owns its slot, and the
+// other nodes reply to commands about it with a MOVED redirect, to the owner
+// (or while the slot is being migrated, an ASK redirect). So the collectors
+// which report on particular keys (like -probe and -stream) are keyed, and on
+// cluster nodes, their commands are routed to the node which owns the key:
+//
+//   - For a cluster node which was given as a target of its own, redirects are
+//     followed, on a connection to the owner kept by the collector, so the
+//     keys are reported as the target's wherever they are in the cluster.
+//   - For the nodes of a discovered cluster, every node runs the collector,
+//     so each only reports the keys it owns, and skips the rest (replicas
+//     skip them all). The metrics are reported under the cluster, rather
+//     than the node, so that they carry on as one series after a key's slot
+//     moves to another node.
+//
+// -pattern is keyed too, so that its estimates, which its masters sum across
+// the cluster (see patterns.go), are reported under the cluster.
+
+// errNotOwned is returned by a keyed collector's commands on a discovered
+// cluster node which doesn't own the key.
+var errNotOwned = errors.New("key is owned by another node")
+
+// A shardConn is a keyed collector's connection to a cluster node, which
+// routes the commands about keys the node doesn't own.
+type shardConn struct {
+	redis.Conn
+	b *backgroundRun
+
+	// Whether to follow redirects, or return errNotOwned.
+	follow bool
+}
+
+// collectConn returns the connection which the collector should use, which
+// is routed if it's keyed, and the target is a cluster node (as of when INFO
+// was last collected).
+func (b *backgroundRun) collectConn() redis.Conn {
+	if !b.c.keyed {
+		return b.conn
+	}
+
+	b.target.mu.Lock()
+	role := b.target.role
+	b.target.mu.Unlock()
+
+	if !strings.HasPrefix(role, "cluster_") {
+		return b.conn
+	}
+
+	return &shardConn{Conn: b.conn, b: b, follow: b.target.seed == ""}
+}
+
+func (c *shardConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	asking, addr, ok := redirect(err)
+	if !ok {
+		return reply, err
+	}
+
+	if !c.follow {
+		return nil, errNotOwned
+	}
+
+	conn, err := c.b.redirected(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if asking {
+		_, err = conn.Do("ASKING")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return conn.Do(cmd, args...)
+}
+
+// redirect returns the address which the error redirects to, if it's a MOVED
+// or ASK redirect, and whether it's an ASK.
+func redirect(err error) (bool, string, bool) {
+	re, ok := err.(redis.Error)
+	if !ok {
+		return false, "", false
+	}
+
+	// e.g. MOVED 3999 127.0.0.1:6381
+	fields := strings.Fields(string(re))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return false, "", false
+	}
+
+	return fields[0] == "ASK", fields[2], true
+}
+
+// redirected returns the collector's connection to the node at addr,
+// connecting to it (with the target's options) if needed. It must be called
+// with b.mu held.
+func (b *backgroundRun) redirected(addr string) (redis.Conn, error) {
+	if conn, ok := b.redirects[addr]; ok {
+		return conn, nil
+	}
+
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	o := b.target.ConnOptions
+	o.Socket = ""
+
+	conn, err := dialRedis(host, port, o, b.target.wrap)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.redirects == nil {
+		b.redirects = map[string]redis.Conn{}
+	}
+
+	b.redirects[addr] = conn
+	return conn, nil
+}
+
+// dropRedirects closes the collector's connections to other nodes. It must be
+// called with b.mu held.
+func (b *backgroundRun) dropRedirects() {
+	for addr, conn := range b.redirects {
+		conn.Close()
+		delete(b.redirects, addr)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+)
+
+var (
+	outputBudget = flag.Duration("output-budget", 0, "the longest each output may spend writing each interval's metrics; past it, the sections of the lowest priority are left out of it until it catches up, starting with the verbose ones (0 for no limit)")
+)
+
+// An output which is slow to accept metrics (a congested pipe to collectd, or
+// a backend which is slow to reply) holds up collection, since metrics are
+// written as they're collected. With an -output-budget, each output's writes
+// are timed, and once an interval's have taken longer than the budget, the
+// sections of the lowest priority (see priority.go) are shed from the output:
+// left out of what it's sent, from then on. After an interval which takes
+// less than half the budget, the priority shed last is sent again. The core
+// sections are never shed.
+//
+// Each output reports how many metrics it shed, and how many priority classes
+// it's shedding, in the output section of the self instance.
+
+// shedOutput sheds the sections of the lowest priorities from its output
+// while it's too slow.
+type shedOutput struct {
+	Output
+	name   string
+	budget time.Duration
+
+	// The priorities below level are shed. The interval being written, how
+	// long it's taken so far, and whether it's gone over the budget.
+	level int
+	cur   time.Time
+	spent time.Duration
+	over  bool
+
+	shed int64
+}
+
+func newShedOutput(name string, out Output, budget time.Duration) *shedOutput {
+	return &shedOutput{Output: out, name: name, budget: budget}
+}
+
+func (o *shedOutput) Write(t time.Time, ms Metrics) error {
+	if !t.Equal(o.cur) {
+		if !o.cur.IsZero() && !o.over && o.spent < o.budget/2 && o.level > 0 {
+			o.level--
+		}
+
+		o.cur = t
+		o.spent = 0
+		o.over = false
+		ms = append(o.metrics(), ms...)
+	}
+
+	kept := ms
+	if o.level > 0 {
+		kept = make(Metrics, 0, len(ms))
+		for _, m := range ms {
+			if priorityOf(m.Section) >= o.level {
+				kept = append(kept, m)
+			}
+		}
+
+		o.shed += int64(len(ms) - len(kept))
+	}
+
+	start := time.Now()
+	err := o.Output.Write(t, kept)
+	o.spent += time.Since(start)
+
+	if o.spent > o.budget && !o.over {
+		o.over = true
+		if o.level < priorityCore {
+			o.level++
+		}
+	}
+
+	return err
+}
+
+// metrics returns the output's own metrics.
+func (o *shedOutput) metrics() Metrics {
+	ms := make(Metrics, 0, 2)
+	add := func(key string, n int64) {
+		ms = append(ms, &Metric{
+			Instance: selfInstance,
+			Section:  "output",
+			Prefix:   o.name,
+			Key:      key,
+			Value:    strconv.FormatInt(n, 10),
+		})
+	}
+
+	add("shed_metrics", o.shed)
+	add("shed_classes", int64(o.level))
+	return ms
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The instance which metrics about the collector itself, rather than any one
+// target, are reported as.
+const selfInstance = "self"
+
+// The targets are collected from one after another, so the more of them there
+// are (and the slower they are) the later in the interval the last one is
+// collected. The skew is how long it was from the first to the last, and if
+// it's most of the interval, the metrics of different targets no longer line
+// up, and soon intervals are missed.
+
+// skewWarning is the fraction of the interval which the skew can be before
+// it's warned about.
+const skewWarning = 0.8
+
+// skewWarned is true if the skew has been warned about, so that it's only
+// warned about once until it recovers.
+var skewWarned bool
+
+// writeSkew writes the skew of the targets collected in the interval starting
+// at now, and warns if it's close to the interval.
+func writeSkew(now time.Time, skew, interval time.Duration, out Output) {
+	ms := Metrics{&Metric{
+		Instance: selfInstance,
+		Section:  "collector",
+		Key:      "collection_skew_seconds",
+		Value:    strconv.FormatFloat(skew.Seconds(), 'f', -1, 64),
+	}}
+
+	err := out.Write(now, ms)
+	if err != nil {
+		fmt.Println("error writing metrics:")
+		fmt.Println(err)
+	}
+
+	near := skew >= time.Duration(float64(interval)*skewWarning)
+	if near && !skewWarned {
+		fmt.Printf("warning: the targets were collected over %s, which is close to the interval (%s); raise the interval, or split the targets between collectors\n", skew, interval)
+	}
+
+	skewWarned = near
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	slowlogClients    = flag.Bool("slowlog-clients", false, "report which clients the new entries of the slow log came from each interval")
+	slowlogClientsTop = flag.Int("slowlog-clients-top", 5, "report this many of the clients with the most slow log entries each interval")
+	slowlogLen        = flag.Int("slowlog-len", 128, "how many of the latest slow log entries to fetch each interval")
+	slowlogSummary    = flag.Bool("slowlog", false, "report the length of the slow log, and how many entries were added to it each interval, and how slow they were")
+	slowlogReset      = flag.Bool("slowlog-reset", false, "reset the slow log once it's been read, so that -slowlog-len entries are always enough to see every new one; needs -safe=false")
+)
+
+// A slowEntry is an entry of the slow log. Since Redis 4, entries include the
+// address and name of the client which sent the command.
+type slowEntry struct {
+	id       int64
+	duration int64
+	client   string
+}
+
+// parseSlowlog parses the reply to SLOWLOG GET. Each entry is an array of its
+// id, timestamp, duration (in microseconds), arguments, client address and
+// client name. Like redis.Values, it takes the error from conn.Do too.
+func parseSlowlog(reply interface{}, err error) ([]slowEntry, error) {
+	vs, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]slowEntry, 0, len(vs))
+	for _, v := range vs {
+		fields, err := redis.Values(v, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) < 3 {
+			continue
+		}
+
+		e := slowEntry{}
+		e.id, err = redis.Int64(fields[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		e.duration, err = redis.Int64(fields[2], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) >= 6 {
+			e.client = slowlogClient(fields[4], fields[5])
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// A slowlogCursor keeps track of how far through the slow log a collector is,
+// by the id of the newest entry it's seen.
+type slowlogCursor struct {
+	last    int64
+	started bool
+}
+
+// next returns the entries which are newer than the ones seen last time. The
+// first time, it only finds out where the log is up to, and returns false. If
+// the ids went backwards, the server restarted, so every entry is new.
+func (c *slowlogCursor) next(entries []slowEntry) ([]slowEntry, bool) {
+	newest := int64(-1)
+	for _, e := range entries {
+		if e.id > newest {
+			newest = e.id
+		}
+	}
+
+	prev := c.last
+	c.last = newest
+	if !c.started {
+		c.started = true
+		return nil, false
+	}
+
+	if newest < prev {
+		prev = -1
+	}
+
+	fresh := make([]slowEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.id > prev {
+			fresh = append(fresh, e)
+		}
+	}
+
+	return fresh, true
+}
+
+// slowlogClient returns what to call the client with the given address and
+// name: its name if it has one, else its host. The port is left out, since
+// it's different for each connection.
+func slowlogClient(addr, name interface{}) string {
+	if n, _ := redis.String(name, nil); n != "" {
+		return n
+	}
+
+	a, _ := redis.String(addr, nil)
+	if host, _, err := net.SplitHostPort(a); err == nil {
+		return host
+	}
+
+	return a
+}
+
+// slowlogClientsCollector reports the slow log entries which were added since
+// the last run, by the client which they came from, so that the blame for
+// slow commands lands on the right service. Only the -slowlog-clients-top
+// clients with the most entries are reported, along with the totals.
+var slowlogClientsCollector = &collector{
+	name:     "slowlog_clients",
+	commands: []string{"SLOWLOG GET"},
+	newCollect: func(*Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+		cur := &slowlogCursor{}
+
+		return func(conn redis.Conn, t time.Time) (Metrics, error) {
+			entries, err := parseSlowlog(conn.Do("SLOWLOG", "GET", *slowlogLen))
+			if err != nil {
+				return nil, err
+			}
+
+			entries, ok := cur.next(entries)
+			if !ok {
+				return nil, nil
+			}
+
+			type blame struct {
+				client   string
+				entries  int
+				duration int64
+			}
+
+			byClient := map[string]*blame{}
+			total := &blame{}
+			for _, e := range entries {
+				b, ok := byClient[e.client]
+				if !ok {
+					b = &blame{client: e.client}
+					byClient[e.client] = b
+				}
+
+				b.entries++
+				b.duration += e.duration
+				total.entries++
+				total.duration += e.duration
+			}
+
+			list := make([]*blame, 0, len(byClient))
+			for _, b := range byClient {
+				list = append(list, b)
+			}
+
+			sort.Slice(list, func(i, j int) bool {
+				if list[i].entries != list[j].entries {
+					return list[i].entries > list[j].entries
+				}
+				return list[i].client < list[j].client
+			})
+
+			if len(list) > *slowlogClientsTop {
+				list = list[:*slowlogClientsTop]
+			}
+
+			ms := make(Metrics, 0, len(list)*2+2)
+			add := func(prefix, key string, n int64) {
+				ms = append(ms, &Metric{Section: "slowlog", Prefix: prefix, Key: key, Value: strconv.FormatInt(n, 10)})
+			}
+
+			for _, b := range list {
+				client := b.client
+				if client == "" {
+					client = "unknown"
+				}
+
+				add("client_"+client, "entries", int64(b.entries))
+				add("client_"+client, "duration_usec", b.duration)
+			}
+
+			add("", "new_entries", int64(total.entries))
+			add("", "new_entries_duration_usec", total.duration)
+			return ms, nil
+		}
+	},
+}
+
+// slowlogCollector returns the collector which reports the length of the slow
+// log, and how many entries were added since the last run, with the longest
+// and 99th percentile of their durations. If more than -slowlog-len entries
+// were added, only the latest are seen, so the count is low; -slowlog-reset
+// avoids that (on servers where nothing else reads the log).
+func slowlogCollector() *collector {
+	commands := []string{"SLOWLOG LEN", "SLOWLOG GET"}
+	if *slowlogReset {
+		commands = append(commands, "SLOWLOG RESET")
+	}
+
+	return &collector{
+		name:     "slowlog",
+		commands: commands,
+		newCollect: func(*Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+			cur := &slowlogCursor{}
+
+			return func(conn redis.Conn, t time.Time) (Metrics, error) {
+				n, err := redis.Int64(conn.Do("SLOWLOG", "LEN"))
+				if err != nil {
+					return nil, err
+				}
+
+				entries, err := parseSlowlog(conn.Do("SLOWLOG", "GET", *slowlogLen))
+				if err != nil {
+					return nil, err
+				}
+
+				// The ids carry on from where they were after a reset,
+				// so the cursor still works.
+				if *slowlogReset {
+					_, err = conn.Do("SLOWLOG", "RESET")
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				ms := Metrics{&Metric{Section: "slowlog", Key: "length", Value: strconv.FormatInt(n, 10)}}
+				entries, ok := cur.next(entries)
+				if !ok {
+					return ms, nil
+				}
+
+				durations := make([]int64, len(entries))
+				for i, e := range entries {
+					durations[i] = e.duration
+				}
+
+				sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+				max, p99 := int64(0), int64(0)
+				if len(durations) > 0 {
+					max = durations[len(durations)-1]
+					p99 = durations[(len(durations)*99+99)/100-1]
+				}
+
+				add := func(key string, n int64) {
+					ms = append(ms, &Metric{Section: "slowlog", Key: key, Value: strconv.FormatInt(n, 10)})
+				}
+
+				add("recent_entries", int64(len(entries)))
+				add("recent_max_duration_usec", max)
+				add("recent_p99_duration_usec", p99)
+				return ms, nil
+			}
+		},
+	}
+}
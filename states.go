@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+)
+
+var (
+	stateSets = flag.Bool("state-sets", false, "also report fields of INFO which are one of a set of states (e.g. role) as a 0 or 1 metric per state")
+)
+
+// The fields of INFO which are enumerations, and their possible states. With
+// -state-sets, each is also reported as one metric per state, named like
+// memory/maxmemory_policy/allkeys-lru, which is 1 for the field's state and 0
+// for the others, so that backends which can't store strings can still show
+// (and alert on) them. A state which isn't listed here is reported as other.
+var stateFields = map[[2]string][]string{
+	{"server", "redis_mode"}: {"standalone", "cluster", "sentinel"},
+
+	{"memory", "maxmemory_policy"}: {
+		"noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random",
+		"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+	},
+
+	{"persistence", "aof_enabled"}:               {"0", "1"},
+	{"persistence", "rdb_bgsave_in_progress"}:    {"0", "1"},
+	{"persistence", "aof_rewrite_in_progress"}:   {"0", "1"},
+	{"persistence", "rdb_last_bgsave_status"}:    {"ok", "err"},
+	{"persistence", "aof_last_bgrewrite_status"}: {"ok", "err"},
+	{"persistence", "aof_last_write_status"}:     {"ok", "err"},
+	{"replication", "role"}:                      {"master", "slave"},
+	{"replication", "master_link_status"}:        {"up", "down"},
+	{"replication", "master_failover_state"}:     {"no-failover", "waiting-for-sync", "failover-in-progress"},
+	{"cluster", "cluster_enabled"}:               {"0", "1"},
+}
+
+// appendStates appends the state set of m to ms, if it's an enumeration.
+func appendStates(ms Metrics, m *Metric) Metrics {
+	states, ok := stateFields[[2]string{m.Section, m.Key}]
+	if !ok {
+		return ms
+	}
+
+	known := false
+	for _, s := range states {
+		v := "0"
+		if s == m.Value {
+			v = "1"
+			known = true
+		}
+
+		ms = append(ms, &Metric{Instance: m.Instance, Section: m.Section, Prefix: m.Key, Key: s, Value: v})
+	}
+
+	v := "1"
+	if known {
+		v = "0"
+	}
+
+	return append(ms, &Metric{Instance: m.Instance, Section: m.Section, Prefix: m.Key, Key: "other", Value: v})
+}
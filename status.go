@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var (
+	statusFile  = flag.String("status-file", "", "path to write the collector's status to as json (when each target was last collected or failed to be, and which version of redis it runs), for health checks")
+	statusEvery = flag.Duration("status-every", time.Minute, "how often to write the -status-file")
+)
+
+// The status file is the admin api's /status, for tools which would rather
+// read a file than make a request (or where the api isn't served), plus the
+// collectors which are enabled, and what's known of each target: whether
+// it's paused, what role it has, and which version of redis it runs.
+
+// A statusReport is the contents of the status file.
+type statusReport struct {
+	Written    time.Time                `json:"written"`
+	Collectors []string                 `json:"collectors"`
+	Targets    map[string]*targetStatus `json:"targets"`
+}
+
+// A targetStatus is what's known of a target, for the status file.
+type targetStatus struct {
+	Addr       string                    `json:"addr"`
+	Paused     bool                      `json:"paused"`
+	Role       string                    `json:"role,omitempty"`
+	Version    string                    `json:"version,omitempty"`
+	Collectors map[string]scheduleStatus `json:"collectors"`
+}
+
+// When the status file is next due to be written.
+var statusAt time.Time
+
+// writeStatus writes the status file, if it's due at now. Errors are logged,
+// and it's tried again next time.
+func writeStatus(now time.Time, targets *Targets, cs []*collector) {
+	if *statusFile == "" || now.Before(statusAt) {
+		return
+	}
+
+	statusAt = now.Add(*statusEvery)
+
+	r := &statusReport{
+		Written:    now,
+		Collectors: []string{"info"},
+		Targets:    map[string]*targetStatus{},
+	}
+
+	for _, c := range cs {
+		r.Collectors = append(r.Collectors, c.name)
+	}
+
+	// Whether each target is paused is guarded by the targets, so it comes
+	// from the copies.
+	paused := map[string]bool{}
+	for _, t := range targets.List() {
+		paused[t.Name] = t.Paused
+	}
+
+	for _, t := range targets.all() {
+		st := t.statusReport()
+		st.Paused = paused[t.Name]
+		r.Targets[t.Name] = st
+	}
+
+	err := writeJSONFile(*statusFile, r)
+	if err != nil {
+		fmt.Println("error writing status file:")
+		fmt.Println(err)
+	}
+}
+
+// statusReport returns what's known of the target, for the status file.
+func (t *Target) statusReport() *targetStatus {
+	st := &targetStatus{Addr: t.Addr(), Collectors: t.status()}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st.Role = t.role
+	st.Version = t.meta["redis_version"]
+	return st
+}
+
+// writeJSONFile writes v to the path as json, via a temporary file which is
+// renamed over it, so that readers never see it half written.
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
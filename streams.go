@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	streamKeys = listFlag{}
+)
+
+func init() {
+	flag.Var(&streamKeys, "stream", "report the length of a stream, how long ago its last entry was added, and the pending entries, consumers and lag of each of its consumer groups (repeatable)")
+}
+
+// Streams used as pipelines fall behind quietly: the producers keep adding
+// entries, and nothing says the consumers aren't keeping up, until the
+// stream is trimmed past entries they haven't read. XINFO says how long each
+// stream is, and for each of its consumer groups, how many entries have been
+// delivered but not acknowledged (pending), and how many haven't been
+// delivered yet (lag, on redis 7 and later).
+//
+// Each stream is reported in the streams section, with its key as the
+// prefix, and each group with the key and the group's name, e.g. jobs_workers.
+// Streams which don't exist are skipped.
+
+// streamsCollector reports each of the -stream streams.
+var streamsCollector = &collector{
+	name:     "streams",
+	commands: []string{"XINFO STREAM", "XINFO GROUPS"},
+	keyed:    true,
+	collect: func(conn redis.Conn, t time.Time) (Metrics, error) {
+		ms := make(Metrics, 0)
+		for _, key := range streamKeys {
+			sms, err := streamMetrics(conn, key, t)
+			if err != nil {
+				return nil, err
+			}
+
+			ms = append(ms, sms...)
+		}
+
+		return ms, nil
+	},
+}
+
+// streamMetrics returns the metrics of the stream and its groups, as of now,
+// or none if it doesn't exist.
+func streamMetrics(conn redis.Conn, key string, now time.Time) (Metrics, error) {
+	reply, err := redis.Values(conn.Do("XINFO", "STREAM", key))
+	if err == errNotOwned {
+		return nil, nil
+	}
+
+	if re, ok := err.(redis.Error); ok && strings.Contains(string(re), "no such key") {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := valueMap(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make(Metrics, 0)
+	add := func(prefix, name string, n int64) {
+		ms = append(ms, &Metric{Section: "streams", Prefix: prefix, Key: name, Value: strconv.FormatInt(n, 10)})
+	}
+
+	length, _ := redis.Int64(info["length"], nil)
+	groups, _ := redis.Int64(info["groups"], nil)
+	add(key, "length", length)
+	add(key, "groups", groups)
+
+	// Entry ids start with the time they were added (in ms), as long as the
+	// server generated them.
+	last, _ := redis.String(info["last-generated-id"], nil)
+	if at, ok := streamIDTime(last); ok && length > 0 {
+		age := now.Sub(at) / time.Millisecond
+		if age < 0 {
+			age = 0
+		}
+
+		add(key, "last_entry_age_ms", int64(age))
+	}
+
+	list, err := redis.Values(conn.Do("XINFO", "GROUPS", key))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range list {
+		g, err := valueMap(v)
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := redis.String(g["name"], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := key + "_" + name
+		pending, _ := redis.Int64(g["pending"], nil)
+		consumers, _ := redis.Int64(g["consumers"], nil)
+		add(prefix, "pending", pending)
+		add(prefix, "consumers", consumers)
+
+		// The lag is nil when the server can't work it out (e.g. after
+		// entries were deleted from the middle of the stream).
+		if lag, err := redis.Int64(g["lag"], nil); err == nil {
+			add(prefix, "lag", lag)
+		}
+	}
+
+	return ms, nil
+}
+
+// streamIDTime returns the time in a stream entry id, e.g. 1700000000000-0.
+func streamIDTime(id string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
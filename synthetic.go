@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	syntheticInstances = flag.Int("synthetic-instances", 0, "fabricate metrics for this many fake instances instead of connecting to redis (for load testing)")
+	syntheticMetrics   = flag.Int("synthetic-metrics", 1000, "number of metrics fabricated per synthetic instance per interval")
+	syntheticCycles    = flag.Int("synthetic-cycles", 0, "run this many synthetic intervals back to back, then report throughput and exit")
+)
+
+// syntheticConn is a fake connection which answers INFO with a fabricated
+// reply containing the given number of metrics. Most are commandstats (three
+// per line), so that both paths through the parser are exercised. The values
+// change every interval, like real counters.
+type syntheticConn struct {
+	redis.Conn
+	metrics int
+	n       int
+}
+
+func (c *syntheticConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "INFO" {
+		return nil, fmt.Errorf("synthetic instances don't support %s", cmd)
+	}
+
+	c.n += 1
+
+	var b bytes.Buffer
+	cmds := c.metrics / 4
+	plain := c.metrics - (cmds * 3)
+
+	b.WriteString("# Stats\r\n")
+	for i := 0; i < plain; i++ {
+		fmt.Fprintf(&b, "synthetic_%d:%d\r\n", i, c.n*i)
+	}
+
+	b.WriteString("\r\n# Commandstats\r\n")
+	for i := 0; i < cmds; i++ {
+		fmt.Fprintf(&b, "cmdstat_synthetic%d:calls=%d,usec=%d,usec_per_call=%.2f\r\n", i, c.n*i, c.n*i*3, 3.0)
+	}
+
+	return b.Bytes(), nil
+}
+
+func (c *syntheticConn) Close() error {
+	return nil
+}
+
+// getSyntheticTargets returns n fake targets.
+func getSyntheticTargets(n int) (*Targets, error) {
+	ts := &Targets{}
+
+	for i := 0; i < n; i++ {
+		c := &syntheticConn{metrics: *syntheticMetrics}
+		err := ts.Add(&Target{
+			Name: fmt.Sprintf("synthetic%d", i),
+			Host: "synthetic",
+			dial: func() (redis.Conn, error) {
+				return c, nil
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ts, nil
+}
+
+// runSynthetic collects from the synthetic targets for -synthetic-cycles
+// intervals as fast as possible, and reports throughput and allocations to
+// stderr (since stdout carries the output).
+func runSynthetic(targets *Targets, interval time.Duration, out Output) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	t := start
+	for i := 0; i < *syntheticCycles; i++ {
+		collect(t, targets, out)
+		t = t.Add(interval)
+	}
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+
+	n := float64(*syntheticInstances * *syntheticMetrics * *syntheticCycles)
+	fmt.Fprintf(os.Stderr, "# synthetic: %d intervals of %d instances x %d metrics in %s\n", *syntheticCycles, *syntheticInstances, *syntheticMetrics, elapsed)
+	fmt.Fprintf(os.Stderr, "# synthetic: %.0f metrics/sec, %.1f allocs/metric, %.1f bytes/metric, %d MB heap in use\n",
+		n/elapsed.Seconds(),
+		float64(after.Mallocs-before.Mallocs)/n,
+		float64(after.TotalAlloc-before.TotalAlloc)/n,
+		after.HeapInuse/(1024*1024))
+}
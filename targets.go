@@ -0,0 +1,666 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	targetsPath = flag.String("targets", "", "path to a yaml file listing the redis servers to monitor (instead of -host and -port)")
+)
+
+// A Target is a Redis server to collect metrics from.
+type Target struct {
+	Name   string `yaml:"name,omitempty" json:"name"`
+	Host   string `yaml:"host" json:"host"`
+	Port   int    `yaml:"port" json:"port"`
+	Paused bool   `yaml:"paused,omitempty" json:"paused"`
+
+	// How to connect to the target, overriding the flags.
+	ConnOptions `yaml:",inline"`
+
+	// How often to run each collector, overriding -schedule.
+	Schedule map[string]string `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+
+	// Commands never to send to the target, as well as -block.
+	Block []string `yaml:"block,omitempty" json:"block,omitempty"`
+
+	// Whether to report the target's metrics as its master's, while it's a
+	// replica, as well as -report-as-master.
+	ReportAsMaster bool `yaml:"report_as_master,omitempty" json:"report_as_master,omitempty"`
+
+	// The name of the cluster which the target is a node of, if any. The
+	// metrics of the nodes of each cluster are also aggregated.
+	Cluster string `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+
+	// The names of the groups which the target belongs to (e.g. cache, or
+	// sessions), whose metrics are rolled up too, like a cluster's.
+	Groups []string `yaml:"groups,omitempty" json:"groups,omitempty"`
+
+	// Whether to collect from every node of the target's cluster, instead of
+	// just the target. See discover.go.
+	Discover bool `yaml:"discover,omitempty" json:"discover,omitempty"`
+
+	// The name of a master to find with the sentinels (instead of the host
+	// and port), and follow through failovers. See sentinel.go.
+	SentinelMaster string   `yaml:"sentinel_master,omitempty" json:"sentinel_master,omitempty"`
+	Sentinels      []string `yaml:"sentinels,omitempty" json:"sentinels,omitempty"`
+
+	// Which background collectors to run, how many may run at once, and how
+	// many keys to scan each run, overriding the flags. See limits.go.
+	Collectors  []string `yaml:"collectors,omitempty" json:"collectors,omitempty"`
+	Concurrency int      `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
+	ScanBudget  int      `yaml:"scan_budget,omitempty" json:"scan_budget,omitempty"`
+
+	// How to connect to the target. If nil, it's dialed normally.
+	dial func() (redis.Conn, error)
+
+	// Held while collecting, and guards the fields below.
+	mu      sync.Mutex
+	conn    redis.Conn
+	removed bool
+
+	// When to next try to connect, how long it waited last time, and
+	// whether the last collection failed (see reconnect.go).
+	retryAt time.Time
+	backoff time.Duration
+	failing bool
+
+	// If the target is a seed, when to next discover its nodes, and if it's
+	// a node, the name of its seed.
+	discoverAt time.Time
+	seed       string
+
+	// The connection to a sentinel, the address of the master which it last
+	// gave, and how many times that's changed.
+	sentinel  redis.Conn
+	master    string
+	failovers int64
+
+	// The fields which metrics were derived (or aggregated) from last
+	// interval.
+	prev *sample
+
+	// The role of the server, as of last interval (see profile.go), and the
+	// instance its metrics were reported as (see mirror.go).
+	role     string
+	reportAs string
+
+	// The metadata which was last reported (see metadata.go).
+	meta map[string]string
+
+	// The limits which were last read with CONFIG GET, if any (see
+	// utilization.go).
+	limits map[string]float64
+
+	// The background collectors, by name, funcs to call when the target is
+	// closed or its master moves, for the ones with connections of their
+	// own, and the semaphore of the ones running, if their concurrency is
+	// limited.
+	background map[string]*backgroundRun
+	closers    []func()
+	movers     []func()
+	running    chan struct{}
+
+	// Guards the schedule of each collector, and the ones which were denied
+	// permission to run, by name.
+	schedMu sync.Mutex
+	sched   map[string]*scheduled
+	denials map[string]error
+}
+
+// A cycler is a connection which needs to know when each interval starts.
+type cycler interface {
+	cycle(t time.Time) error
+}
+
+// Addr returns the address of the target: its host and port, the path of its
+// unix socket, or the name of its master.
+func (t *Target) Addr() string {
+	if t.SentinelMaster != "" {
+		return t.SentinelMaster
+	}
+
+	if t.Socket != "" {
+		return t.Socket
+	}
+
+	return fmt.Sprintf("%s:%d", t.Host, t.Port)
+}
+
+// Collect fetches the metrics from the target for the interval starting at
+// now. See Stream.
+func (t *Target) Collect(now time.Time) (Metrics, error) {
+	ms := Metrics{}
+	err := t.Stream(now, 0, func(b Metrics) error {
+		ms = append(ms, b...)
+		return nil
+	})
+
+	return ms, err
+}
+
+// Stream fetches the metrics from the target for the interval starting at
+// now, connecting first if needed, and passes them to fn in batches of about
+// size (see streamInfo). The last batch is a separate one. It starts with the
+// series of the sections with a top limit, which can only be picked once all
+// of INFO has been read. Then come the derived metrics, the count of unknown
+// fields and the state sets. Last are the metrics of CLUSTER INFO, the
+// metadata (if it changed) and the sentinel's metrics, where there are any.
+//
+// If the fetch goes wrong, the connection is dropped, to be redialed next
+// time, and if that fails, with backoff.
+func (t *Target) Stream(now time.Time, size int, fn func(Metrics) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.removed {
+		return nil
+	}
+
+	// If the master has moved, the connection to the old one is dropped.
+	// If the sentinels can't say, the old one is collected from until they
+	// can.
+	if t.conn != nil && t.SentinelMaster != "" {
+		err := t.followMaster()
+		if err != nil {
+			fmt.Printf("error asking the sentinels of %s where the master is:\n", t.Name)
+			fmt.Println(err)
+		}
+	}
+
+	if t.conn == nil {
+		if now.Before(t.retryAt) {
+			return errBackingOff
+		}
+
+		conn, err := t.connect()
+		if err != nil {
+			t.retryLater(now)
+			return err
+		}
+
+		t.conn = conn
+		t.backoff = 0
+	}
+
+	if c, ok := t.conn.(cycler); ok {
+		err := c.cycle(now)
+		if err != nil {
+			return err
+		}
+	}
+
+	blob, err := fetchInfo(t.conn)
+	if err != nil {
+		t.conn.Close()
+		t.conn = nil
+		return err
+	}
+
+	var cur *sample
+	if *derivedMetrics || t.Cluster != "" || len(t.Groups) > 0 {
+		cur = newSample(now)
+	}
+
+	// Replicas which are reported as their masters are only streamed once
+	// it's known who the master is.
+	instance := t.Name
+	mirror := t.mirrors()
+	if mirror {
+		size = 0
+	}
+
+	// The series of sections with a top limit are held back until they've
+	// all been seen, to rank them.
+	role, mode := "", ""
+	unknown := 0
+	meta := map[string]string{}
+	var states, held Metrics
+	err = streamInfo(blob, size, func(ms Metrics) error {
+		if mirror {
+			instance = mirroredInstance(t.Name, ms)
+		}
+
+		for _, m := range ms {
+			m.Instance = instance
+			if cur != nil {
+				cur.observe(m)
+			}
+
+			if *unknownFields != "off" && observeUnknown(m) {
+				unknown++
+			}
+
+			if *stateSets {
+				states = appendStates(states, m)
+			}
+
+			if *reportMetadata {
+				observeMetadata(meta, m)
+			}
+
+			switch {
+			case m.Section == "replication" && m.Key == "role":
+				role = m.Value
+			case m.Section == "server" && m.Key == "redis_mode":
+				mode = m.Value
+			}
+		}
+
+		if len(parsedTops) > 0 {
+			kept := ms[:0]
+			for _, m := range ms {
+				if limited(m) {
+					held = append(held, m)
+				} else {
+					kept = append(kept, m)
+				}
+			}
+
+			ms = kept
+		}
+
+		return fn(ms)
+	})
+	if err != nil {
+		return err
+	}
+
+	t.role = roleOf(role, mode)
+	t.reportAs = instance
+
+	ms := applyTop(held)
+	if cur != nil {
+		cur.role = t.role
+		cur.observeLimits(t.limits)
+		if *derivedMetrics && profileRuns(t.role, "derived") {
+			ms = append(ms, derive(instance, t.prev, cur)...)
+		}
+
+		t.prev = cur
+	}
+
+	if *unknownFields != "off" {
+		ms = append(ms, unknownMetric(instance, unknown))
+	}
+
+	ms = append(ms, states...)
+
+	// The cluster section of INFO only says whether it's a cluster node, so
+	// the rest comes from CLUSTER INFO. If the server refuses it (e.g. for
+	// lack of permission), it's left out.
+	if mode == "cluster" && !t.blocked("CLUSTER INFO") {
+		cm, err := fetchClusterInfo(t.conn)
+		if _, refused := err.(redis.Error); err != nil && !refused {
+			t.conn.Close()
+			t.conn = nil
+			return err
+		}
+
+		for _, m := range cm {
+			m.Instance = instance
+			observeMetadata(meta, m)
+		}
+
+		ms = append(ms, cm...)
+	}
+
+	if *reportMetadata {
+		if md := metadataChanged(instance, t.meta, meta); md != nil {
+			ms = append(ms, md...)
+			t.meta = meta
+		}
+	}
+
+	if t.SentinelMaster != "" {
+		sm, err := t.sentinelMetrics(instance)
+		if err != nil {
+			fmt.Printf("error fetching sentinel metrics for %s:\n", t.Name)
+			fmt.Println(err)
+		}
+
+		ms = append(ms, sm...)
+	}
+
+	if len(ms) == 0 {
+		return nil
+	}
+
+	return fn(ms)
+}
+
+func (t *Target) connect() (redis.Conn, error) {
+	if t.dial != nil {
+		return t.dial()
+	}
+
+	host, port, err := t.dialAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialRedis(host, port, t.ConnOptions, t.wrap)
+	if err != nil {
+		return nil, err
+	}
+
+	if *recordDir != "" {
+		return newRecordingConn(conn, t.Name)
+	}
+
+	return conn, nil
+}
+
+// dialAddr returns the host and port to connect to the target at, which for a
+// master monitored by sentinel is wherever the sentinel says it is now. It
+// must be called with t.mu held.
+func (t *Target) dialAddr() (string, int, error) {
+	if t.SentinelMaster == "" {
+		return t.Host, t.Port, nil
+	}
+
+	err := t.followMaster()
+	if err != nil {
+		return "", 0, err
+	}
+
+	return splitHostPort(t.master)
+}
+
+// wrap adds the layers which every command sent to the target goes through.
+func (t *Target) wrap(conn redis.Conn) redis.Conn {
+	if audit != nil {
+		conn = &auditConn{Conn: conn, target: t.Name}
+	}
+
+	if *safeMode {
+		conn = &safeConn{conn}
+	}
+
+	conn = &blockConn{Conn: conn, target: t}
+
+	return conn
+}
+
+// close disconnects from the target, and stops it from being collected.
+func (t *Target) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removed = true
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+
+	t.dropSentinel()
+
+	// The background collectors might be running, so they're closed once
+	// they're done rather than waited for.
+	for _, b := range t.background {
+		go b.close()
+	}
+
+	for _, fn := range t.closers {
+		fn()
+	}
+}
+
+// Targets is the set of targets being monitored. It can be changed at runtime
+// (via the admin api), and if it was loaded from a file, the changes can be
+// saved back to it.
+type Targets struct {
+	mu   sync.Mutex
+	list []*Target
+	path string
+}
+
+// getTargets returns the targets listed in the -targets file, or if there
+// isn't one, the servers listed in the config file, or if there are none, the
+// single target given by -host and -port (or -socket), named by -instance.
+// When replaying, there's a target for each recording instead, and when load
+// testing there are synthetic targets.
+func getTargets(servers []*Target) (*Targets, error) {
+	if *replayDir != "" {
+		return getReplayTargets(*replayDir)
+	}
+
+	if *syntheticInstances > 0 {
+		return getSyntheticTargets(*syntheticInstances)
+	}
+
+	if *targetsPath == "" && len(servers) > 0 {
+		ts := &Targets{}
+		for _, t := range servers {
+			err := ts.Add(t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: servers: %s", *configPath, err)
+			}
+		}
+
+		return ts, nil
+	}
+
+	if *targetsPath == "" {
+		ts := &Targets{}
+		t := &Target{Name: *instance, Host: *redisHost, Port: *redisPort}
+		t.Socket = *redisSocket
+		if *sentinelMaster != "" {
+			t.SentinelMaster, t.Sentinels = *sentinelMaster, sentinelAddrs
+		}
+
+		t.Discover = *clusterDiscover
+		return ts, ts.Add(t)
+	}
+
+	b, err := ioutil.ReadFile(*targetsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Target
+	err = yaml.Unmarshal(b, &list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", *targetsPath, err)
+	}
+
+	ts := &Targets{path: *targetsPath}
+	for _, t := range list {
+		err = ts.Add(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", *targetsPath, err)
+		}
+	}
+
+	return ts, nil
+}
+
+// Add adds a target to the set. The port defaults to 6379, and the name to
+// the address. A seed's own node is discovered along with the rest, under its
+// address, so a seed is named seed:ADDR instead, with its cluster named after
+// the address (unless it has a name).
+func (ts *Targets) Add(t *Target) error {
+	if t.Host == "" && t.Socket == "" && t.SentinelMaster == "" {
+		return fmt.Errorf("target has no host (or socket, or sentinel master)")
+	}
+
+	if t.SentinelMaster != "" && len(t.Sentinels) == 0 {
+		return fmt.Errorf("%s: sentinel_master needs sentinels", t.SentinelMaster)
+	}
+
+	if t.Port == 0 {
+		t.Port = 6379
+	}
+
+	if t.Name == "" {
+		t.Name = t.Addr()
+		if t.Discover {
+			if t.Cluster == "" {
+				t.Cluster = t.Name
+			}
+
+			t.Name = "seed:" + t.Name
+		}
+	}
+
+	err := checkSchedule(t.Schedule)
+	if err != nil {
+		return fmt.Errorf("%s: schedule: %s", t.Name, err)
+	}
+
+	err = t.ConnOptions.check()
+	if err != nil {
+		return fmt.Errorf("%s: %s", t.Name, err)
+	}
+
+	err = checkBlock(t.Block)
+	if err != nil {
+		return fmt.Errorf("%s: block: %s", t.Name, err)
+	}
+
+	for _, g := range t.Groups {
+		if g == "" {
+			return fmt.Errorf("%s: groups: empty group name", t.Name)
+		}
+	}
+
+	err = t.checkLimits()
+	if err != nil {
+		return fmt.Errorf("%s: %s", t.Name, err)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, o := range ts.list {
+		if o.Name == t.Name {
+			return fmt.Errorf("duplicate target: %s", t.Name)
+		}
+	}
+
+	ts.list = append(ts.list, t)
+	return nil
+}
+
+// Remove removes the named target from the set, and disconnects from it.
+func (ts *Targets) Remove(name string) error {
+	ts.mu.Lock()
+
+	for i, t := range ts.list {
+		if t.Name == name {
+			ts.list = append(ts.list[:i], ts.list[i+1:]...)
+			ts.mu.Unlock()
+
+			t.close()
+			return nil
+		}
+	}
+
+	ts.mu.Unlock()
+	return fmt.Errorf("no such target: %s", name)
+}
+
+// SetPaused pauses or unpauses collection from the named target.
+func (ts *Targets) SetPaused(name string, paused bool) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, t := range ts.list {
+		if t.Name == name {
+			t.Paused = paused
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no such target: %s", name)
+}
+
+// List returns copies of the targets, for reporting.
+func (ts *Targets) List() []*Target {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	list := make([]*Target, len(ts.list))
+	for i, t := range ts.list {
+		list[i] = &Target{Name: t.Name, Host: t.Host, Port: t.Port, Paused: t.Paused, ConnOptions: t.ConnOptions, Schedule: t.Schedule, Block: t.Block, ReportAsMaster: t.ReportAsMaster, Cluster: t.Cluster, Groups: t.Groups, SentinelMaster: t.SentinelMaster, Sentinels: t.Sentinels, Collectors: t.Collectors, Concurrency: t.Concurrency, ScanBudget: t.ScanBudget, Discover: t.Discover, seed: t.seed}
+	}
+
+	return list
+}
+
+// all returns every target, including the paused ones.
+func (ts *Targets) all() []*Target {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return append([]*Target{}, ts.list...)
+}
+
+// Active returns the targets which should be collected from this interval.
+// Seeds aren't, since their nodes are.
+func (ts *Targets) Active() []*Target {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	list := make([]*Target, 0, len(ts.list))
+	for _, t := range ts.list {
+		if !t.Paused && !t.Discover {
+			list = append(list, t)
+		}
+	}
+
+	return list
+}
+
+// Save writes the targets back to the file they were loaded from, except the
+// discovered nodes. It's an error to call this if they weren't loaded from a
+// file.
+func (ts *Targets) Save() error {
+	if ts.path == "" {
+		return fmt.Errorf("targets weren't loaded from a file")
+	}
+
+	list := make([]*Target, 0)
+	for _, t := range ts.List() {
+		if t.seed == "" {
+			list = append(list, t)
+		}
+	}
+
+	b, err := yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it over the original, so that a
+	// crash can't leave a truncated file behind. The file can hold passwords,
+	// so it keeps the original's mode, or if it's gone, is only readable by
+	// the collector's user.
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(ts.path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	tmp := ts.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, mode)
+	if err != nil {
+		return err
+	}
+
+	// WriteFile leaves the mode of an existing file alone (e.g. one left by
+	// an earlier crash), and is subject to the umask.
+	err = os.Chmod(tmp, mode)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, ts.path)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// getTLSConfig builds a client TLS config. The CA, cert and key are paths to
+// PEM files, and are all optional.
+func getTLSConfig(ca, cert, key string, skipVerify bool) (*tls.Config, error) {
+	c := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ca)
+		}
+	}
+
+	if cert != "" || key != "" {
+		kp, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Certificates = []tls.Certificate{kp}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	topLimits = mapFlag{}
+)
+
+func init() {
+	flag.Var(topLimits, "top", "only report the n series of a section with the largest value of a field (or the most growth in it, with delta), and sum the rest as other, as section=n:field[:delta], e.g. commandstats=10:usec:delta (repeatable)")
+}
+
+// Some sections have a series (a prefix) for each of something which there can
+// be a lot of, e.g. commandstats has one per command which has been run, and
+// errorstats one per kind of error. On a busy server, reporting all of them
+// can be more metrics than the rest put together. A top limit on a section
+// reports only the n series which matter most, by one of their fields (or by
+// how much it grew since last time, for counters), and sums the fields of the
+// rest into a series of their own named other (e.g. cmdstat_other). Averages
+// (fields with _per_ in the name) can't be summed, so they're left out of it.
+//
+// Limits apply to the sections of INFO, and of the background collectors, by
+// name.
+
+// A topLimit is how many series of a section to report, and by which field
+// they're ranked.
+type topLimit struct {
+	n     int
+	key   string
+	delta bool
+}
+
+// The parsed -top limits, by section.
+var parsedTops map[string]topLimit
+
+// checkTop parses the -top limits, or returns an error if any of them are
+// invalid.
+func checkTop(limits map[string]string) error {
+	parsed := make(map[string]topLimit, len(limits))
+	for section, s := range limits {
+		fields := strings.Split(s, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[1] == "" {
+			return fmt.Errorf("%s: expected n:field[:delta], got %q", section, s)
+		}
+
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("%s: n must be at least 1, got %q", section, fields[0])
+		}
+
+		l := topLimit{n: n, key: fields[1]}
+		if len(fields) == 3 {
+			if fields[2] != "delta" {
+				return fmt.Errorf("%s: expected delta, got %q", section, fields[2])
+			}
+
+			l.delta = true
+		}
+
+		parsed[section] = l
+	}
+
+	parsedTops = parsed
+	return nil
+}
+
+// A topSeries is the metrics of one prefix of a limited section.
+type topSeries struct {
+	prefix string
+	ms     Metrics
+	rank   float64
+}
+
+// A topKey identifies a series of a target, for working out how much its
+// field grew.
+type topKey struct {
+	instance, section, prefix string
+}
+
+var (
+	topMu   sync.Mutex
+	topPrev = map[topKey]float64{}
+)
+
+// limited returns true if the metric is in a series of a limited section.
+func limited(m *Metric) bool {
+	_, ok := parsedTops[m.Section]
+	return ok && m.Prefix != ""
+}
+
+// applyTop returns the metrics, with only the top series of each limited
+// section, and the other series summed. Every series of a section (of each
+// instance) must be in ms, for the ranking to be right.
+func applyTop(ms Metrics) Metrics {
+	if len(parsedTops) == 0 {
+		return ms
+	}
+
+	type group struct {
+		instance, section string
+	}
+
+	out := make(Metrics, 0, len(ms))
+	groups := make([]group, 0)
+	series := map[group]map[string]*topSeries{}
+
+	for _, m := range ms {
+		if !limited(m) {
+			out = append(out, m)
+			continue
+		}
+
+		g := group{m.Instance, m.Section}
+		byPrefix, ok := series[g]
+		if !ok {
+			byPrefix = map[string]*topSeries{}
+			series[g] = byPrefix
+			groups = append(groups, g)
+		}
+
+		s, ok := byPrefix[m.Prefix]
+		if !ok {
+			s = &topSeries{prefix: m.Prefix}
+			byPrefix[m.Prefix] = s
+		}
+
+		s.ms = append(s.ms, m)
+	}
+
+	topMu.Lock()
+	defer topMu.Unlock()
+
+	for _, g := range groups {
+		out = append(out, topOf(g.instance, g.section, series[g])...)
+	}
+
+	return out
+}
+
+// topOf ranks the series of a section, and returns the metrics of the top
+// ones, followed by the sum of the rest.
+func topOf(instance, section string, byPrefix map[string]*topSeries) Metrics {
+	l := parsedTops[section]
+	list := make([]*topSeries, 0, len(byPrefix))
+	for _, s := range byPrefix {
+		for _, m := range s.ms {
+			if m.Key != l.key {
+				continue
+			}
+
+			f, err := m.Float()
+			if err != nil {
+				continue
+			}
+
+			s.rank = f
+			if l.delta {
+				k := topKey{instance, section, s.prefix}
+				s.rank = f - topPrev[k]
+				topPrev[k] = f
+			}
+		}
+
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].rank != list[j].rank {
+			return list[i].rank > list[j].rank
+		}
+		return list[i].prefix < list[j].prefix
+	})
+
+	out := make(Metrics, 0)
+	for i, s := range list {
+		if i == l.n {
+			break
+		}
+
+		out = append(out, s.ms...)
+	}
+
+	if len(list) <= l.n {
+		return out
+	}
+
+	keys := make([]string, 0)
+	sums := map[string]float64{}
+	for _, s := range list[l.n:] {
+		for _, m := range s.ms {
+			f, err := m.Float()
+			if err != nil || strings.Contains(m.Key, "_per_") {
+				continue
+			}
+
+			if _, ok := sums[m.Key]; !ok {
+				keys = append(keys, m.Key)
+			}
+
+			sums[m.Key] += f
+		}
+	}
+
+	other := otherPrefix(list[0].prefix)
+	for _, k := range keys {
+		out = append(out, &Metric{
+			Instance: instance,
+			Section:  section,
+			Prefix:   other,
+			Key:      k,
+			Value:    strconv.FormatFloat(sums[k], 'f', -1, 64),
+		})
+	}
+
+	return out
+}
+
+// otherPrefix returns the prefix of the sum of the series which aren't in the
+// top, named like the others, e.g. cmdstat_other for cmdstat_get.
+func otherPrefix(prefix string) string {
+	if i := strings.IndexByte(prefix, '_'); i >= 0 {
+		return prefix[:i+1] + "other"
+	}
+
+	return "other"
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	ttlCoverage     = flag.Bool("ttl-coverage", false, "estimate what percentage of keys have no ttl, and how long the ttls of the rest are, from a sample")
+	ttlCoverageKeys = flag.Int("ttl-coverage-keys", 200, "how many keys of each database to sample each run, for -ttl-coverage")
+	ttlCoverageDBs  = flag.String("ttl-coverage-dbs", "", "the databases to sample for -ttl-coverage, comma separated (default the target's)")
+)
+
+// A key written without a TTL (by a bug, or a client which forgot) stays
+// until something deletes it, so a cache which is meant to expire slowly fills
+// up instead. INFO's keyspace section says how many keys have a TTL, but only
+// for the whole database, and not how long they are. So each run, some keys
+// of each database are sampled with SCAN (carrying on from where the last run
+// left off), and asked their PTTL. They're reported in the ttl section, with
+// the database (e.g. db0) as the prefix: the percentage of the sample which
+// had no TTL, and the shortest, median and 95th percentile of the rest, every
+// minute by default.
+
+// The parsed -ttl-coverage-dbs databases. If empty, the target's is sampled.
+var parsedTTLDBs []int
+
+// checkTTLCoverage parses the -ttl-coverage flags, or returns an error if
+// they're invalid.
+func checkTTLCoverage() error {
+	if *ttlCoverageKeys < 1 {
+		return fmt.Errorf("-ttl-coverage-keys must be at least 1")
+	}
+
+	dbs, err := parseDBList(*ttlCoverageDBs)
+	if err != nil {
+		return fmt.Errorf("-ttl-coverage-dbs: %s", err)
+	}
+
+	parsedTTLDBs = dbs
+	return nil
+}
+
+var ttlCoverageCollector = &collector{
+	name:     "ttl_coverage",
+	commands: []string{"SELECT", "SCAN", "PTTL"},
+	every:    time.Minute,
+	newCollect: func(tg *Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+		home := intOr(tg.ConnOptions.DB, *redisDB)
+		keys := tg.scanBudget(*ttlCoverageKeys)
+		dbs := parsedTTLDBs
+		if len(dbs) == 0 {
+			dbs = []int{home}
+		}
+
+		// The SCAN cursor of each database.
+		cursors := map[int]string{}
+		for _, db := range dbs {
+			cursors[db] = "0"
+		}
+
+		return func(conn redis.Conn, t time.Time) (Metrics, error) {
+			cur := home
+			ms := make(Metrics, 0)
+			for _, db := range dbs {
+				err := selectDB(conn, &cur, db)
+				if err != nil {
+					return nil, err
+				}
+
+				cursor, dms, err := sampleTTLs(conn, cursors[db], keys, fmt.Sprintf("db%d", db))
+				if err != nil {
+					return nil, err
+				}
+
+				cursors[db] = cursor
+				ms = append(ms, dms...)
+			}
+
+			err := selectDB(conn, &cur, home)
+			if err != nil {
+				return nil, err
+			}
+
+			return ms, nil
+		}
+	},
+}
+
+// sampleTTLs samples the TTLs of n keys of the connection's database, scanning
+// from the cursor, and returns where to carry on from next time, and the
+// metrics of the sample, with the prefix.
+func sampleTTLs(conn redis.Conn, cursor string, n int, prefix string) (string, Metrics, error) {
+	sampled, none := 0, 0
+	ttls := make([]int64, 0, n)
+
+	for sampled < n {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", 100))
+		if err != nil {
+			return "", nil, err
+		}
+
+		var keys []string
+		_, err = redis.Scan(reply, &cursor, &keys)
+		if err != nil {
+			return "", nil, err
+		}
+
+		for _, k := range keys {
+			if sampled == n {
+				break
+			}
+
+			pttl, err := redis.Int64(conn.Do("PTTL", k))
+			if err != nil {
+				return "", nil, err
+			}
+
+			// -2 means the key's gone since it was scanned, and -1 that it
+			// has no TTL.
+			switch {
+			case pttl == -2:
+				continue
+			case pttl == -1:
+				none++
+			default:
+				ttls = append(ttls, pttl)
+			}
+
+			sampled++
+		}
+
+		// Small databases are sampled whole, rather than twice over.
+		if cursor == "0" {
+			break
+		}
+	}
+
+	ms := make(Metrics, 0, 5)
+	add := func(key string, f float64) {
+		ms = append(ms, &Metric{Section: "ttl", Prefix: prefix, Key: key, Value: strconv.FormatFloat(f, 'f', -1, 64)})
+	}
+
+	add("sampled_keys", float64(sampled))
+	if sampled == 0 {
+		return cursor, ms, nil
+	}
+
+	add("no_ttl_percent", float64(none)/float64(sampled)*100)
+
+	if len(ttls) > 0 {
+		sort.Slice(ttls, func(i, j int) bool { return ttls[i] < ttls[j] })
+		secs := func(ms int64) float64 { return float64(ms) / 1000 }
+
+		add("ttl_min_seconds", secs(ttls[0]))
+		add("ttl_median_seconds", secs(ttls[(len(ttls)*50+99)/100-1]))
+		add("ttl_p95_seconds", secs(ttls[(len(ttls)*95+99)/100-1]))
+	}
+
+	return cursor, ms, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	utilization = flag.Bool("utilization", false, "report how much of maxmemory and maxclients is used, as memory/used_memory_percent and clients/connected_clients_percent, with the limits from CONFIG GET (or INFO, if CONFIG is refused)")
+)
+
+// How much memory the server uses, or how many clients are connected, says
+// little without the limit: 4GB is fine under a maxmemory of 16GB, and about
+// to evict (or refuse writes) under one of 4GB. The limits are read with
+// CONFIG GET every minute, by the utilization collector, and kept on the
+// target until the next time. The percentages are derived from them each
+// interval, along with the rest of the derived metrics. Servers which refuse
+// CONFIG (e.g. it's renamed, or not allowed by the ACLs) are skipped, and the
+// limits are taken from INFO instead, where it has them (7.0 and later, for
+// maxclients). A maxmemory of zero means there's no limit, so nothing is
+// reported.
+
+// The config parameters which are limits, and the section and field of INFO
+// which also has each of them.
+var limitParams = [][3]string{
+	{"maxmemory", "memory", "maxmemory"},
+	{"maxclients", "clients", "maxclients"},
+}
+
+var utilizationCollector = &collector{
+	name:     "utilization",
+	commands: []string{"CONFIG GET"},
+	every:    time.Minute,
+	newCollect: func(tg *Target) func(conn redis.Conn, t time.Time) (Metrics, error) {
+		return func(conn redis.Conn, t time.Time) (Metrics, error) {
+			limits := map[string]float64{}
+			for _, p := range limitParams {
+				cfg, err := redis.StringMap(conn.Do("CONFIG", "GET", p[0]))
+				if _, refused := err.(redis.Error); refused {
+					return nil, nil
+				}
+
+				if err != nil {
+					return nil, err
+				}
+
+				if f, err := strconv.ParseFloat(cfg[p[0]], 64); err == nil {
+					limits[p[0]] = f
+				}
+			}
+
+			tg.mu.Lock()
+			tg.limits = limits
+			tg.mu.Unlock()
+			return nil, nil
+		}
+	},
+}
+
+// observeLimits records the limits in the sample, in the config section, for
+// deriveUtilization.
+func (s *sample) observeLimits(limits map[string]float64) {
+	for k, f := range limits {
+		s.values[[2]string{"config", k}] = f
+	}
+}
+
+// limit returns the limit of the config parameter p, from CONFIG GET if it was
+// read, or INFO if not.
+func (d *derivation) limit(p [3]string) (float64, bool) {
+	if f, ok := d.value("config", p[0]); ok {
+		return f, true
+	}
+
+	return d.value(p[1], p[2])
+}
+
+// deriveUtilization reports the used memory and connected clients as
+// percentages of their limits.
+func deriveUtilization(d *derivation) {
+	if !*utilization {
+		return
+	}
+
+	if max, ok := d.limit(limitParams[0]); ok && max > 0 {
+		if used, ok := d.value("memory", "used_memory"); ok {
+			d.emit("memory", "used_memory_percent", used/max*100)
+		}
+	}
+
+	if max, ok := d.limit(limitParams[1]); ok && max > 0 {
+		if connected, ok := d.value("clients", "connected_clients"); ok {
+			d.emit("clients", "connected_clients_percent", connected/max*100)
+		}
+	}
+}
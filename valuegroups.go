@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+)
+
+// A valueGroup is a set of related fields which can be reported together, as
+// a single multi-value collectd type. The types are defined in types.db.
+type valueGroup struct {
+	section string
+
+	// If set, the group is reported separately for each metric prefix which
+	// begins with this, e.g. once per database. Otherwise, the group applies
+	// to unprefixed metrics, and is named after its section.
+	prefix string
+
+	typ  string
+	keys []string
+}
+
+var valueGroups = []*valueGroup{
+	{"keyspace", "db", "redis_keyspace", []string{"keys", "expires"}},
+	{"commandstats", "cmdstat_", "redis_command", []string{"calls", "usec"}},
+	{"memory", "", "redis_memory", []string{"used_memory", "used_memory_rss", "used_memory_peak"}},
+}
+
+// A valueSet is an instance of a valueGroup, with its values.
+type valueSet struct {
+	group    *valueGroup
+	instance string
+	values   []float64
+	metrics  Metrics
+}
+
+// groupValues pulls the metrics which belong to value groups out of ms. It
+// returns the complete value sets, and the rest of the metrics (including any
+// from incomplete sets) to be reported individually.
+func groupValues(ms Metrics) ([]*valueSet, Metrics) {
+	sets := make([]*valueSet, 0)
+	byName := map[string]*valueSet{}
+	rest := make(Metrics, 0, len(ms))
+
+	for _, m := range ms {
+		g, i := findValueGroup(m)
+		if g == nil {
+			rest = append(rest, m)
+			continue
+		}
+
+		f, err := m.Float()
+		if err != nil {
+			rest = append(rest, m)
+			continue
+		}
+
+		instance := m.Prefix
+		if g.prefix == "" {
+			instance = g.section
+		}
+
+		k := g.typ + "/" + instance
+		vs, ok := byName[k]
+		if !ok {
+			vs = &valueSet{
+				group:    g,
+				instance: instance,
+				values:   make([]float64, len(g.keys)),
+				metrics:  make(Metrics, len(g.keys)),
+			}
+			byName[k] = vs
+			sets = append(sets, vs)
+		}
+
+		vs.values[i] = f
+		vs.metrics[i] = m
+	}
+
+	complete := make([]*valueSet, 0, len(sets))
+	for _, vs := range sets {
+		ok := true
+		for _, m := range vs.metrics {
+			if m == nil {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			complete = append(complete, vs)
+			continue
+		}
+
+		for _, m := range vs.metrics {
+			if m != nil {
+				rest = append(rest, m)
+			}
+		}
+	}
+
+	return complete, rest
+}
+
+// findValueGroup returns the group which m belongs to, and its index within
+// the group, or nil if it doesn't belong to one.
+func findValueGroup(m *Metric) (*valueGroup, int) {
+	for _, g := range valueGroups {
+		if m.Section != g.section {
+			continue
+		}
+
+		if g.prefix == "" {
+			if m.Prefix != "" {
+				continue
+			}
+		} else if !strings.HasPrefix(m.Prefix, g.prefix) {
+			continue
+		}
+
+		for i, k := range g.keys {
+			if m.Key == k {
+				return g, i
+			}
+		}
+	}
+
+	return nil, 0
+}